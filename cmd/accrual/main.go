@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/accrual"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/logger"
+)
+
+const (
+	defaultAddress         = "localhost:8080"
+	defaultLogLevel        = "info"
+	defaultProcessingDelay = time.Second
+	shutdownTimeout        = 5 * time.Second
+)
+
+type config struct {
+	address         string
+	logLevel        string
+	rateLimit       int
+	processingDelay time.Duration
+}
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatalf("accrual error: %v", err)
+	}
+}
+
+func buildConfig() (config, error) {
+	cfg := config{
+		address:         defaultAddress,
+		logLevel:        defaultLogLevel,
+		processingDelay: defaultProcessingDelay,
+	}
+
+	if addr, ok := os.LookupEnv("RUN_ADDRESS"); ok {
+		cfg.address = addr
+	}
+	if level, ok := os.LookupEnv("LOG_LEVEL"); ok {
+		cfg.logLevel = level
+	}
+	if limit, ok := os.LookupEnv("RATE_LIMIT"); ok {
+		rpm, err := strconv.Atoi(limit)
+		if err != nil {
+			return config{}, fmt.Errorf("can not parse rate_limit as int: %w", err)
+		}
+		cfg.rateLimit = rpm
+	}
+	if delay, ok := os.LookupEnv("PROCESSING_DELAY"); ok {
+		dur, err := time.ParseDuration(delay + "s")
+		if err != nil {
+			return config{}, fmt.Errorf("can not parse processing_delay as duration: %w", err)
+		}
+		cfg.processingDelay = dur
+	}
+
+	flag.StringVar(&cfg.address, "a", cfg.address, "address and port to run accrual")
+	flag.StringVar(&cfg.logLevel, "l", cfg.logLevel, "log level")
+	flag.IntVar(&cfg.rateLimit, "rl", cfg.rateLimit, "max requests per minute to get order info, 0 - no limit")
+	flag.DurationVar(&cfg.processingDelay, "pd", cfg.processingDelay, "delay of every processing step")
+	flag.Parse()
+
+	return cfg, nil
+}
+
+func run() error {
+	cfg, err := buildConfig()
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+
+	loggerInst := logger.NewLogrusLogger()
+	err = loggerInst.SetLevelForLog(cfg.logLevel)
+	if err != nil {
+		return fmt.Errorf("parse log level error: %w", err)
+	}
+
+	router := chi.NewRouter()
+	accrualHandler := accrual.NewHandler(
+		accrual.NewService(cfg.processingDelay),
+		loggerInst,
+		cfg.rateLimit,
+	)
+	accrualHandler.InitChiRouter(router)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	server := &http.Server{
+		Addr:    cfg.address,
+		Handler: router,
+	}
+	errCh := make(chan error, 1)
+
+	loggerInst.LogrusLog.Infof("Start accrual on %s", cfg.address)
+	go func() {
+		defer close(errCh)
+
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+	}()
+
+	var serverErr error
+	select {
+	case <-ctx.Done():
+		loggerInst.LogrusLog.Info("Got stop signal")
+	case err := <-errCh:
+		serverErr = fmt.Errorf("server error: %w", err)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		loggerInst.LogrusLog.Errorf("failed drain HTTP requests: %v", err)
+	}
+
+	loggerInst.LogrusLog.Info("Accrual stopped")
+	return serverErr
+}
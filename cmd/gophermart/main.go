@@ -65,50 +65,92 @@ func run() error {
 		backoffInst,
 		checkRetryFunc,
 		cfg.JWTConfig,
+		cfg.ExpiryConfig,
 	)
 
 	if err != nil {
 		loggerInst.LogrusLog.Errorf("failed create storage: %v", err)
 		return fmt.Errorf("failed create storage: %w", err)
 	}
-	defer func() {
-		err := retryStore.Close()
-		if err != nil {
-			loggerInst.LogrusLog.Errorf("can not close storage: %v", err)
-		}
-	}()
 
 	router := chi.NewRouter()
 
-	repoHandler := handler.NewRepositorieHandler(retryStore, loggerInst, cfg.JWTConfig, cfg.ClientConfig.Address)
+	repoHandler, err := handler.NewRepositorieHandler(
+		retryStore,
+		loggerInst,
+		cfg.JWTConfig,
+		cfg.ClientConfig,
+		cfg.PoolConfig,
+		cfg.BreakerConfig,
+		cfg.AdminConfig,
+		cfg.IdemConfig,
+		cfg.ExpiryConfig,
+		cfg.HoldConfig,
+	)
+	if err != nil {
+		loggerInst.LogrusLog.Errorf("failed create handler: %v", err)
+		if errClose := retryStore.Close(); errClose != nil {
+			loggerInst.LogrusLog.Errorf("can not close storage: %v", errClose)
+		}
+		return fmt.Errorf("failed create handler: %w", err)
+	}
 
 	repoHandler.InitChiRouter(router)
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer stop()
 
-	serverCtx := context.WithoutCancel(ctx)
-	errCh := make(chan error)
+	poolDone := make(chan struct{})
+	go func() {
+		defer close(poolDone)
+		repoHandler.RunPool(ctx)
+	}()
+
+	server := &http.Server{
+		Addr:    cfg.SConfig.Address,
+		Handler: router,
+	}
+	errCh := make(chan error, 1)
 
 	loggerInst.LogrusLog.Infof("Start Server on %s", cfg.SConfig.Address)
-	go func(ctx context.Context, errCh chan error) {
+	go func() {
 		defer close(errCh)
 
-		select {
-		case errCh <- http.ListenAndServe(cfg.SConfig.Address, router):
-			return
-		case <-ctx.Done():
-			return
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
 		}
-	}(serverCtx, errCh)
+	}()
 
+	var serverErr error
 	select {
 	case <-ctx.Done():
-		log.Println("Got stop signal")
+		loggerInst.LogrusLog.Info("Got stop signal")
 	case err := <-errCh:
-		stop()
-		log.Printf("fatal error: %v", err)
+		loggerInst.LogrusLog.Errorf("fatal error: %v", err)
+		serverErr = fmt.Errorf("server error: %w", err)
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.SConfig.ShutdownTimeout)
+	defer cancel()
+
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		loggerInst.LogrusLog.Errorf("failed drain HTTP requests: %v", err)
+	}
+
+	select {
+	case <-poolDone:
+	case <-shutdownCtx.Done():
+		loggerInst.LogrusLog.Error("pool of workers did not stop in time")
+	}
+
+	err = retryStore.Close()
+	if err != nil {
+		loggerInst.LogrusLog.Errorf("can not close storage: %v", err)
 	}
 
-	return nil
+	loggerInst.LogrusLog.Info("Server stopped")
+	return serverErr
 }
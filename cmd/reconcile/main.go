@@ -0,0 +1,101 @@
+// Reconcile reports every account whose cached balance or withdrawn
+// differs from the ledger, one JSON object per line, and exits with
+// non-zero code if there is any.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/config"
+	"github.com/zhenyanesterkova/gmloyalty/internal/repository/postgres"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/logger"
+)
+
+const (
+	defaultLogLevel = "info"
+)
+
+var errDiscrepancies = errors.New("accounts disagree with the ledger")
+
+type reconcileConfig struct {
+	dsn      string
+	logLevel string
+}
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatalf("reconcile error: %v", err)
+	}
+}
+
+func buildConfig() reconcileConfig {
+	cfg := reconcileConfig{
+		logLevel: defaultLogLevel,
+	}
+
+	if dsn, ok := os.LookupEnv("DATABASE_URI"); ok {
+		cfg.dsn = dsn
+	}
+	if level, ok := os.LookupEnv("LOG_LEVEL"); ok {
+		cfg.logLevel = level
+	}
+
+	flag.StringVar(&cfg.dsn, "d", cfg.dsn, "database source name")
+	flag.StringVar(&cfg.logLevel, "l", cfg.logLevel, "log level")
+	flag.Parse()
+
+	return cfg
+}
+
+func run() error {
+	cfg := buildConfig()
+	if cfg.dsn == "" {
+		return errors.New("database source name is empty")
+	}
+
+	loggerInst := logger.NewLogrusLogger()
+	err := loggerInst.SetLevelForLog(cfg.logLevel)
+	if err != nil {
+		return fmt.Errorf("parse log level error: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	store, err := postgres.Open(cfg.dsn, loggerInst, config.ExpiryConfig{})
+	if err != nil {
+		return fmt.Errorf("failed create storage: %w", err)
+	}
+	defer func() {
+		if err := store.Close(); err != nil {
+			loggerInst.LogrusLog.Errorf("can not close storage: %v", err)
+		}
+	}()
+
+	discrepancies, err := store.Reconcile(ctx)
+	if err != nil {
+		return fmt.Errorf("failed reconcile accounts: %w", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, d := range discrepancies {
+		if err := enc.Encode(d); err != nil {
+			return fmt.Errorf("failed write discrepancy: %w", err)
+		}
+	}
+
+	if len(discrepancies) > 0 {
+		return fmt.Errorf("%w: %d accounts", errDiscrepancies, len(discrepancies))
+	}
+
+	loggerInst.LogrusLog.Info("All accounts agree with the ledger")
+	return nil
+}
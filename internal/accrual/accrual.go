@@ -0,0 +1,170 @@
+package accrual
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/order"
+)
+
+const (
+	RewardTypePercent = "%"
+	RewardTypePoints  = "pt"
+	percentBase       = 100
+	roundBase         = 100
+)
+
+var (
+	ErrOrderExists   = errors.New("order is already registered")
+	ErrRewardExists  = errors.New("reward with this match is already registered")
+	ErrInvalidReward = errors.New("invalid reward")
+)
+
+type Good struct {
+	Description string  `json:"description"`
+	Price       float64 `json:"price"`
+}
+
+type Reward struct {
+	Match      string  `json:"match"`
+	RewardType string  `json:"reward_type"`
+	Reward     float64 `json:"reward"`
+}
+
+type Order struct {
+	Number  string  `json:"order"`
+	Status  string  `json:"status"`
+	Accrual float64 `json:"accrual,omitempty"`
+	goods   []Good
+}
+
+// Service keeps registered orders and reward rules in memory and
+// calculates accruals in the background, moving each order through
+// REGISTERED, PROCESSING and PROCESSED or INVALID.
+type Service struct {
+	orders  map[string]*Order
+	rewards []Reward
+	delay   time.Duration
+	mu      sync.RWMutex
+}
+
+func NewService(processingDelay time.Duration) *Service {
+	return &Service{
+		orders:  map[string]*Order{},
+		rewards: []Reward{},
+		delay:   processingDelay,
+	}
+}
+
+func (s *Service) RegisterOrder(number string, goods []Good) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.orders[number]; ok {
+		return ErrOrderExists
+	}
+
+	s.orders[number] = &Order{
+		Number: number,
+		Status: order.AccrualStatusRegistered,
+		goods:  goods,
+	}
+
+	time.AfterFunc(s.delay, func() {
+		s.setStatus(number, order.AccrualStatusProcessing)
+		time.AfterFunc(s.delay, func() {
+			s.calculate(number)
+		})
+	})
+
+	return nil
+}
+
+func (s *Service) AddReward(reward Reward) error {
+	if reward.Match == "" || reward.Reward < 0 ||
+		(reward.RewardType != RewardTypePercent && reward.RewardType != RewardTypePoints) {
+		return ErrInvalidReward
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.rewards {
+		if r.Match == reward.Match {
+			return ErrRewardExists
+		}
+	}
+	s.rewards = append(s.rewards, reward)
+
+	return nil
+}
+
+func (s *Service) Order(number string) (Order, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	orderData, ok := s.orders[number]
+	if !ok {
+		return Order{}, false
+	}
+	return *orderData, true
+}
+
+func (s *Service) setStatus(number, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if orderData, ok := s.orders[number]; ok {
+		orderData.Status = status
+	}
+}
+
+// calculate applies the first matching reward to every good of the order.
+// Orders without any matching good become INVALID.
+func (s *Service) calculate(number string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	orderData, ok := s.orders[number]
+	if !ok {
+		return
+	}
+
+	var (
+		sum     float64
+		matched bool
+	)
+	for _, good := range orderData.goods {
+		reward, ok := s.findReward(good.Description)
+		if !ok {
+			continue
+		}
+		matched = true
+		switch reward.RewardType {
+		case RewardTypePercent:
+			sum += good.Price * reward.Reward / percentBase
+		case RewardTypePoints:
+			sum += reward.Reward
+		}
+	}
+
+	if !matched {
+		orderData.Status = order.AccrualStatusInvalid
+		return
+	}
+
+	orderData.Accrual = math.Round(sum*roundBase) / roundBase
+	orderData.Status = order.AccrualStatusProcessed
+}
+
+func (s *Service) findReward(description string) (Reward, bool) {
+	for _, reward := range s.rewards {
+		if strings.Contains(description, reward.Match) {
+			return reward, true
+		}
+	}
+	return Reward{}, false
+}
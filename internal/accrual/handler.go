@@ -0,0 +1,172 @@
+package accrual
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/helper"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/logger"
+)
+
+const (
+	TextServerError        = "Something went wrong... Server error"
+	TextInvalidFormatError = "Invalid request format"
+	ContentTypeJSON        = "application/json"
+	ContentType            = "Content-Type"
+)
+
+type registerOrderRequest struct {
+	Number string `json:"order"`
+	Goods  []Good `json:"goods"`
+}
+
+type Handler struct {
+	service *Service
+	limiter *windowLimiter
+	logger  logger.LogrusLogger
+}
+
+func NewHandler(service *Service, log logger.LogrusLogger, rateLimit int) *Handler {
+	return &Handler{
+		service: service,
+		logger:  log,
+		limiter: &windowLimiter{limit: rateLimit},
+	}
+}
+
+func (h *Handler) InitChiRouter(router *chi.Mux) {
+	router.Route("/api", func(r chi.Router) {
+		r.With(h.RateLimit).Get("/orders/{number}", h.GetOrder)
+		r.Post("/orders", h.RegisterOrder)
+		r.Post("/goods", h.AddReward)
+	})
+}
+
+func (h *Handler) GetOrder(w http.ResponseWriter, r *http.Request) {
+	log := h.logger.LogrusLog
+
+	orderData, ok := h.service.Order(chi.URLParam(r, "number"))
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set(ContentType, ContentTypeJSON)
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(orderData); err != nil {
+		log.Errorf("error encode order in get order handler - %v", err)
+		http.Error(w, TextServerError, http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handler) RegisterOrder(w http.ResponseWriter, r *http.Request) {
+	log := h.logger.LogrusLog
+
+	req := registerOrderRequest{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		http.Error(w, TextInvalidFormatError, http.StatusBadRequest)
+		return
+	}
+
+	if !helper.LuhnCheck(req.Number) {
+		http.Error(w, TextInvalidFormatError, http.StatusBadRequest)
+		return
+	}
+
+	err := h.service.RegisterOrder(req.Number, req.Goods)
+	if err != nil {
+		if errors.Is(err, ErrOrderExists) {
+			http.Error(w, "Order is already registered", http.StatusConflict)
+			return
+		}
+		log.Errorf("failed register order: %v", err)
+		http.Error(w, TextServerError, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *Handler) AddReward(w http.ResponseWriter, r *http.Request) {
+	log := h.logger.LogrusLog
+
+	reward := Reward{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&reward); err != nil {
+		http.Error(w, TextInvalidFormatError, http.StatusBadRequest)
+		return
+	}
+
+	err := h.service.AddReward(reward)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidReward):
+			http.Error(w, TextInvalidFormatError, http.StatusBadRequest)
+			return
+		case errors.Is(err, ErrRewardExists):
+			http.Error(w, "Reward with this match is already registered", http.StatusConflict)
+			return
+		}
+		log.Errorf("failed add reward: %v", err)
+		http.Error(w, TextServerError, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) RateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed, retryAfter := h.limiter.allow(time.Now())
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			http.Error(
+				w,
+				fmt.Sprintf("No more than %d requests per minute allowed", h.limiter.limit),
+				http.StatusTooManyRequests,
+			)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// windowLimiter allows at most limit requests per minute.
+// Zero limit disables it.
+type windowLimiter struct {
+	windowStart time.Time
+	limit       int
+	count       int
+	mu          sync.Mutex
+}
+
+func (l *windowLimiter) allow(now time.Time) (bool, time.Duration) {
+	if l.limit <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.windowStart) >= time.Minute {
+		l.windowStart = now
+		l.count = 0
+	}
+
+	if l.count >= l.limit {
+		return false, l.windowStart.Add(time.Minute).Sub(now)
+	}
+	l.count++
+	return true, 0
+}
@@ -0,0 +1,9 @@
+package config
+
+// AdminConfig holds the token of the administrative API and the tokens
+// of trusted merchant integrations by merchant name. An empty token or
+// no merchants disables the API.
+type AdminConfig struct {
+	MerchantTokens map[string]string
+	Token          string
+}
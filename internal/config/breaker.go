@@ -0,0 +1,13 @@
+package config
+
+import "time"
+
+const (
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 30 * time.Second
+)
+
+type BreakerConfig struct {
+	FailureThreshold int
+	Cooldown         time.Duration
+}
@@ -1,5 +1,57 @@
 package config
 
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+const (
+	DefaultProvider        = "default"
+	DefaultRequestTimeout  = 5 * time.Second
+	DefaultIdleConnTimeout = 90 * time.Second
+	DefaultMaxIdleConns    = 100
+)
+
 type CliConfig struct {
-	Address string
+	Address         string
+	WebhookSecret   string
+	CAFile          string
+	CertFile        string
+	KeyFile         string
+	RequestTimeout  time.Duration
+	IdleConnTimeout time.Duration
+	Providers       []ProviderConfig
+	RateLimit       int
+	MaxIdleConns    int
+	MaxConnsPerHost int
+}
+
+// ProviderConfig is an additional accrual system. An order is routed to
+// the first provider whose every set rule matches the order number,
+// orders that match no provider go to the default one at Address.
+type ProviderConfig struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Prefix  string `json:"prefix"`
+	Pattern string `json:"regex"`
+	Length  int    `json:"length"`
+}
+
+func (p ProviderConfig) validate() error {
+	if p.Name == "" || p.Name == DefaultProvider {
+		return fmt.Errorf("invalid provider name %q", p.Name)
+	}
+	if p.Address == "" {
+		return fmt.Errorf("address of provider %s is empty", p.Name)
+	}
+	if p.Prefix == "" && p.Pattern == "" && p.Length <= 0 {
+		return fmt.Errorf("provider %s has no routing rules", p.Name)
+	}
+	if p.Pattern != "" {
+		if _, err := regexp.Compile(p.Pattern); err != nil {
+			return fmt.Errorf("invalid regex of provider %s: %w", p.Name, err)
+		}
+	}
+	return nil
 }
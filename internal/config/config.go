@@ -3,22 +3,30 @@ package config
 import (
 	"errors"
 	"fmt"
+	"os"
 	"time"
 )
 
 type Config struct {
-	SConfig      ServerConfig
-	DBConfig     DBConfig
-	LConfig      LoggerConfig
-	ClientConfig CliConfig
-	JWTConfig    JWTConfig
-	RetryConfig  RetryConfig
+	SConfig       ServerConfig
+	DBConfig      DBConfig
+	LConfig       LoggerConfig
+	ClientConfig  CliConfig
+	JWTConfig     JWTConfig
+	RetryConfig   RetryConfig
+	PoolConfig    PoolConfig
+	BreakerConfig BreakerConfig
+	AdminConfig   AdminConfig
+	IdemConfig    IdempotencyConfig
+	ExpiryConfig  ExpiryConfig
+	HoldConfig    HoldConfig
 }
 
 func New() *Config {
 	return &Config{
 		SConfig: ServerConfig{
-			Address: DefaultServerAddress,
+			Address:         DefaultServerAddress,
+			ShutdownTimeout: DefaultShutdownTimeout,
 		},
 		LConfig: LoggerConfig{
 			Level: DefaultLogLevel,
@@ -33,7 +41,38 @@ func New() *Config {
 			TokenExp:  DefaultTokenExp * time.Hour,
 			SecretKey: DefaultSecretKey,
 		},
-		ClientConfig: CliConfig{},
+		ClientConfig: CliConfig{
+			RequestTimeout:  DefaultRequestTimeout,
+			IdleConnTimeout: DefaultIdleConnTimeout,
+			MaxIdleConns:    DefaultMaxIdleConns,
+		},
+		PoolConfig: PoolConfig{
+			LeaseDuration:    DefaultLeaseDuration,
+			Workers:          DefaultWorkers,
+			QueueSize:        DefaultQueueSize,
+			MinWorkers:       DefaultMinWorkers,
+			MaxWorkers:       DefaultMaxWorkers,
+			ScaleInterval:    DefaultScaleInterval,
+			LatencyThreshold: DefaultLatencyThreshold,
+			MaxAttempts:      DefaultMaxAttempts,
+			OverflowPolicy:   OverflowSpill,
+			OverflowRetry:    DefaultOverflowRetry,
+		},
+		BreakerConfig: BreakerConfig{
+			FailureThreshold: DefaultBreakerThreshold,
+			Cooldown:         DefaultBreakerCooldown,
+		},
+		IdemConfig: IdempotencyConfig{
+			TTL: DefaultIdempotencyTTL,
+		},
+		ExpiryConfig: ExpiryConfig{
+			Months:   DefaultExpiryMonths,
+			Interval: DefaultExpiryInterval,
+			Notice:   DefaultExpiryNotice,
+		},
+		HoldConfig: HoldConfig{
+			Timeout: DefaultHoldTimeout,
+		},
 	}
 }
 
@@ -54,6 +93,63 @@ func (c *Config) Build() error {
 	if c.ClientConfig.Address == "" {
 		return fmt.Errorf("error build config: %w", errors.New("url for client accrual is empty"))
 	}
+	names := map[string]bool{}
+	for _, provider := range c.ClientConfig.Providers {
+		if err := provider.validate(); err != nil {
+			return fmt.Errorf("error build config: %w", err)
+		}
+		if names[provider.Name] {
+			return fmt.Errorf("error build config: duplicate provider %s", provider.Name)
+		}
+		names[provider.Name] = true
+	}
+	if c.ClientConfig.RequestTimeout <= 0 {
+		return fmt.Errorf("error build config: %w", errors.New("accrual request timeout must be positive"))
+	}
+	if (c.ClientConfig.CertFile == "") != (c.ClientConfig.KeyFile == "") {
+		return fmt.Errorf("error build config: %w",
+			errors.New("client certificate and key for accrual must be set together"))
+	}
+	if c.PoolConfig.Workers <= 0 || c.PoolConfig.QueueSize <= 0 || c.PoolConfig.MaxAttempts <= 0 {
+		return fmt.Errorf("error build config: %w",
+			errors.New("count of workers, queue size and max attempts must be positive"))
+	}
+	if c.PoolConfig.Autoscale &&
+		(c.PoolConfig.MinWorkers <= 0 || c.PoolConfig.MinWorkers > c.PoolConfig.MaxWorkers) {
+		return fmt.Errorf("error build config: %w", errors.New("invalid bounds of workers for autoscaling"))
+	}
+	merchantTokens := map[string]bool{}
+	for name, token := range c.AdminConfig.MerchantTokens {
+		if name == "" || token == "" || merchantTokens[token] {
+			return fmt.Errorf("error build config: invalid token of merchant %q", name)
+		}
+		merchantTokens[token] = true
+	}
+	if c.PoolConfig.LeaseDuration <= 0 {
+		return fmt.Errorf("error build config: %w", errors.New("lease duration of accrual jobs must be positive"))
+	}
+	if c.PoolConfig.ScaleInterval <= 0 {
+		return fmt.Errorf("error build config: %w", errors.New("interval of workers autoscaling must be positive"))
+	}
+	if c.ExpiryConfig.Months < 0 || c.ExpiryConfig.Interval <= 0 || c.ExpiryConfig.Notice < 0 {
+		return fmt.Errorf("error build config: %w", errors.New("invalid points expiry settings"))
+	}
+	if c.HoldConfig.Timeout <= 0 {
+		return fmt.Errorf("error build config: %w", errors.New("hold timeout must be positive"))
+	}
+	if c.IdemConfig.TTL <= 0 {
+		return fmt.Errorf("error build config: %w", errors.New("idempotency key ttl must be positive"))
+	}
+	if c.PoolConfig.OverflowPolicy != OverflowSpill && c.PoolConfig.OverflowPolicy != OverflowReject {
+		return fmt.Errorf("error build config: unknown queue overflow policy %q", c.PoolConfig.OverflowPolicy)
+	}
+	if c.PoolConfig.InstanceID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("error build config: can not get instance id from hostname: %w", err)
+		}
+		c.PoolConfig.InstanceID = hostname
+	}
 
 	return nil
 }
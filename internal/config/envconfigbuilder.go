@@ -1,16 +1,27 @@
 package config
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
-func (c *Config) setEnvServerConfig() {
+func (c *Config) setEnvServerConfig() error {
 	if envEndpoint, ok := os.LookupEnv("RUN_ADDRESS3"); ok {
 		c.SConfig.Address = envEndpoint
 	}
+	if timeout, ok := os.LookupEnv("SHUTDOWN_TIMEOUT"); ok {
+		dur, err := time.ParseDuration(timeout + "s")
+		if err != nil {
+			return errors.New("can not parse shutdown_timeout as duration" + err.Error())
+		}
+		c.SConfig.ShutdownTimeout = dur
+	}
+	return nil
 }
 
 func (c *Config) setEnvLoggerConfig() {
@@ -39,20 +50,248 @@ func (c *Config) setJWTConfig() error {
 	return nil
 }
 
-func (c *Config) setClientConfig() {
+func (c *Config) setClientConfig() error {
 	if addr, ok := os.LookupEnv("ACCRUAL_SYSTEM_ADDRESS"); ok {
 		c.ClientConfig.Address = addr
 	}
+	if secret, ok := os.LookupEnv("ACCRUAL_WEBHOOK_SECRET"); ok {
+		c.ClientConfig.WebhookSecret = secret
+	}
+	if limit, ok := os.LookupEnv("ACCRUAL_RATE_LIMIT"); ok {
+		rpm, err := strconv.Atoi(limit)
+		if err != nil {
+			return errors.New("can not parse accrual_rate_limit as int" + err.Error())
+		}
+		c.ClientConfig.RateLimit = rpm
+	}
+
+	if timeout, ok := os.LookupEnv("ACCRUAL_REQUEST_TIMEOUT"); ok {
+		dur, err := time.ParseDuration(timeout + "s")
+		if err != nil {
+			return errors.New("can not parse accrual_request_timeout as duration" + err.Error())
+		}
+		c.ClientConfig.RequestTimeout = dur
+	}
+	if timeout, ok := os.LookupEnv("ACCRUAL_IDLE_CONN_TIMEOUT"); ok {
+		dur, err := time.ParseDuration(timeout + "s")
+		if err != nil {
+			return errors.New("can not parse accrual_idle_conn_timeout as duration" + err.Error())
+		}
+		c.ClientConfig.IdleConnTimeout = dur
+	}
+
+	intVars := map[string]*int{
+		"ACCRUAL_MAX_IDLE_CONNS":     &c.ClientConfig.MaxIdleConns,
+		"ACCRUAL_MAX_CONNS_PER_HOST": &c.ClientConfig.MaxConnsPerHost,
+	}
+	for name, dst := range intVars {
+		if val, ok := os.LookupEnv(name); ok {
+			num, err := strconv.Atoi(val)
+			if err != nil {
+				return errors.New("can not parse " + strings.ToLower(name) + " as int" + err.Error())
+			}
+			*dst = num
+		}
+	}
+
+	if providers, ok := os.LookupEnv("ACCRUAL_PROVIDERS"); ok {
+		err := json.Unmarshal([]byte(providers), &c.ClientConfig.Providers)
+		if err != nil {
+			return errors.New("can not parse accrual_providers as json" + err.Error())
+		}
+	}
+
+	if caFile, ok := os.LookupEnv("ACCRUAL_CA_FILE"); ok {
+		c.ClientConfig.CAFile = caFile
+	}
+	if certFile, ok := os.LookupEnv("ACCRUAL_CLIENT_CERT"); ok {
+		c.ClientConfig.CertFile = certFile
+	}
+	if keyFile, ok := os.LookupEnv("ACCRUAL_CLIENT_KEY"); ok {
+		c.ClientConfig.KeyFile = keyFile
+	}
+	return nil
+}
+
+func (c *Config) setPoolConfig() error {
+	if id, ok := os.LookupEnv("INSTANCE_ID"); ok {
+		c.PoolConfig.InstanceID = id
+	}
+	if lease, ok := os.LookupEnv("ACCRUAL_LEASE_DURATION"); ok {
+		dur, err := time.ParseDuration(lease + "s")
+		if err != nil {
+			return errors.New("can not parse accrual_lease_duration as duration" + err.Error())
+		}
+		c.PoolConfig.LeaseDuration = dur
+	}
+
+	intVars := map[string]*int{
+		"ACCRUAL_WORKERS":      &c.PoolConfig.Workers,
+		"ACCRUAL_QUEUE_SIZE":   &c.PoolConfig.QueueSize,
+		"ACCRUAL_MIN_WORKERS":  &c.PoolConfig.MinWorkers,
+		"ACCRUAL_MAX_WORKERS":  &c.PoolConfig.MaxWorkers,
+		"ACCRUAL_MAX_ATTEMPTS": &c.PoolConfig.MaxAttempts,
+	}
+	for name, dst := range intVars {
+		if val, ok := os.LookupEnv(name); ok {
+			num, err := strconv.Atoi(val)
+			if err != nil {
+				return errors.New("can not parse " + strings.ToLower(name) + " as int" + err.Error())
+			}
+			*dst = num
+		}
+	}
+
+	if autoscale, ok := os.LookupEnv("ACCRUAL_AUTOSCALE"); ok {
+		val, err := strconv.ParseBool(autoscale)
+		if err != nil {
+			return errors.New("can not parse accrual_autoscale as bool" + err.Error())
+		}
+		c.PoolConfig.Autoscale = val
+	}
+	if interval, ok := os.LookupEnv("ACCRUAL_SCALE_INTERVAL"); ok {
+		dur, err := time.ParseDuration(interval + "s")
+		if err != nil {
+			return errors.New("can not parse accrual_scale_interval as duration" + err.Error())
+		}
+		c.PoolConfig.ScaleInterval = dur
+	}
+	if latency, ok := os.LookupEnv("ACCRUAL_LATENCY_THRESHOLD"); ok {
+		dur, err := time.ParseDuration(latency + "ms")
+		if err != nil {
+			return errors.New("can not parse accrual_latency_threshold as duration" + err.Error())
+		}
+		c.PoolConfig.LatencyThreshold = dur
+	}
+	if policy, ok := os.LookupEnv("ACCRUAL_OVERFLOW_POLICY"); ok {
+		c.PoolConfig.OverflowPolicy = policy
+	}
+	if retry, ok := os.LookupEnv("ACCRUAL_OVERFLOW_RETRY_AFTER"); ok {
+		dur, err := time.ParseDuration(retry + "s")
+		if err != nil {
+			return errors.New("can not parse accrual_overflow_retry_after as duration" + err.Error())
+		}
+		c.PoolConfig.OverflowRetry = dur
+	}
+	return nil
+}
+
+func (c *Config) setBreakerConfig() error {
+	if threshold, ok := os.LookupEnv("ACCRUAL_BREAKER_THRESHOLD"); ok {
+		val, err := strconv.Atoi(threshold)
+		if err != nil {
+			return errors.New("can not parse accrual_breaker_threshold as int" + err.Error())
+		}
+		c.BreakerConfig.FailureThreshold = val
+	}
+	if cooldown, ok := os.LookupEnv("ACCRUAL_BREAKER_COOLDOWN"); ok {
+		dur, err := time.ParseDuration(cooldown + "s")
+		if err != nil {
+			return errors.New("can not parse accrual_breaker_cooldown as duration" + err.Error())
+		}
+		c.BreakerConfig.Cooldown = dur
+	}
+	return nil
+}
+
+func (c *Config) setAdminConfig() error {
+	if token, ok := os.LookupEnv("ADMIN_TOKEN"); ok {
+		c.AdminConfig.Token = token
+	}
+	if tokens, ok := os.LookupEnv("MERCHANT_TOKENS"); ok {
+		err := json.Unmarshal([]byte(tokens), &c.AdminConfig.MerchantTokens)
+		if err != nil {
+			return errors.New("can not parse merchant_tokens as json" + err.Error())
+		}
+	}
+	return nil
+}
+
+func (c *Config) setIdempotencyConfig() error {
+	if ttl, ok := os.LookupEnv("IDEMPOTENCY_TTL"); ok {
+		dur, err := time.ParseDuration(ttl + "s")
+		if err != nil {
+			return errors.New("can not parse idempotency_ttl as duration" + err.Error())
+		}
+		c.IdemConfig.TTL = dur
+	}
+	return nil
+}
+
+func (c *Config) setExpiryConfig() error {
+	if months, ok := os.LookupEnv("POINTS_EXPIRY_MONTHS"); ok {
+		num, err := strconv.Atoi(months)
+		if err != nil {
+			return errors.New("can not parse points_expiry_months as int" + err.Error())
+		}
+		c.ExpiryConfig.Months = num
+	}
+	if interval, ok := os.LookupEnv("POINTS_EXPIRY_INTERVAL"); ok {
+		dur, err := time.ParseDuration(interval + "s")
+		if err != nil {
+			return errors.New("can not parse points_expiry_interval as duration" + err.Error())
+		}
+		c.ExpiryConfig.Interval = dur
+	}
+	if notice, ok := os.LookupEnv("POINTS_EXPIRY_NOTICE_DAYS"); ok {
+		days, err := strconv.Atoi(notice)
+		if err != nil {
+			return errors.New("can not parse points_expiry_notice_days as int" + err.Error())
+		}
+		c.ExpiryConfig.Notice = time.Duration(days) * 24 * time.Hour
+	}
+	return nil
+}
+
+func (c *Config) setHoldConfig() error {
+	if timeout, ok := os.LookupEnv("HOLD_TIMEOUT"); ok {
+		dur, err := time.ParseDuration(timeout + "s")
+		if err != nil {
+			return errors.New("can not parse hold_timeout as duration" + err.Error())
+		}
+		c.HoldConfig.Timeout = dur
+	}
+	return nil
 }
 
 func (c *Config) envBuild() error {
-	c.setEnvServerConfig()
+	err := c.setEnvServerConfig()
+	if err != nil {
+		return fmt.Errorf("failed set server config from env: %w", err)
+	}
 	c.setEnvLoggerConfig()
 	c.setDBConfig()
-	err := c.setJWTConfig()
+	err = c.setJWTConfig()
 	if err != nil {
 		return fmt.Errorf("failed set JWT config from env: %w", err)
 	}
-	c.setClientConfig()
+	err = c.setClientConfig()
+	if err != nil {
+		return fmt.Errorf("failed set client config from env: %w", err)
+	}
+	err = c.setPoolConfig()
+	if err != nil {
+		return fmt.Errorf("failed set pool config from env: %w", err)
+	}
+	err = c.setBreakerConfig()
+	if err != nil {
+		return fmt.Errorf("failed set breaker config from env: %w", err)
+	}
+	err = c.setAdminConfig()
+	if err != nil {
+		return fmt.Errorf("failed set admin config from env: %w", err)
+	}
+	err = c.setIdempotencyConfig()
+	if err != nil {
+		return fmt.Errorf("failed set idempotency config from env: %w", err)
+	}
+	err = c.setExpiryConfig()
+	if err != nil {
+		return fmt.Errorf("failed set points expiry config from env: %w", err)
+	}
+	err = c.setHoldConfig()
+	if err != nil {
+		return fmt.Errorf("failed set hold config from env: %w", err)
+	}
 	return nil
 }
@@ -0,0 +1,19 @@
+package config
+
+import "time"
+
+const (
+	DefaultExpiryMonths   = 0
+	DefaultExpiryInterval = time.Hour
+	DefaultExpiryNotice   = 30 * 24 * time.Hour
+)
+
+// ExpiryConfig sets how long accrued points live. Months is the lifetime
+// of credited points, zero disables expiration and is the default,
+// so that upgraded deployments keep their points until it is set. Notice is the period
+// before expiration in which points are reported as expiring soon.
+type ExpiryConfig struct {
+	Interval time.Duration
+	Notice   time.Duration
+	Months   int
+}
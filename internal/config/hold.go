@@ -0,0 +1,13 @@
+package config
+
+import "time"
+
+const (
+	DefaultHoldTimeout = 15 * time.Minute
+)
+
+// HoldConfig sets how long points stay held before they are
+// released automatically.
+type HoldConfig struct {
+	Timeout time.Duration
+}
@@ -0,0 +1,11 @@
+package config
+
+import "time"
+
+const (
+	DefaultIdempotencyTTL = 24 * time.Hour
+)
+
+type IdempotencyConfig struct {
+	TTL time.Duration
+}
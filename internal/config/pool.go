@@ -0,0 +1,38 @@
+package config
+
+import "time"
+
+const (
+	DefaultLeaseDuration    = time.Minute
+	DefaultWorkers          = 20
+	DefaultQueueSize        = 1024
+	DefaultMinWorkers       = 1
+	DefaultMaxWorkers       = 100
+	DefaultScaleInterval    = 5 * time.Second
+	DefaultLatencyThreshold = 2 * time.Second
+	DefaultMaxAttempts      = 10
+	DefaultOverflowRetry    = 10 * time.Second
+)
+
+const (
+	// OverflowSpill accepts new orders when the queue is full and leaves
+	// them in the database until workers catch up.
+	OverflowSpill = "spill"
+	// OverflowReject answers 503 with Retry-After when the queue is full.
+	OverflowReject = "reject"
+)
+
+type PoolConfig struct {
+	InstanceID       string
+	LeaseDuration    time.Duration
+	ScaleInterval    time.Duration
+	LatencyThreshold time.Duration
+	OverflowRetry    time.Duration
+	OverflowPolicy   string
+	Workers          int
+	QueueSize        int
+	MinWorkers       int
+	MaxWorkers       int
+	MaxAttempts      int
+	Autoscale        bool
+}
@@ -1,9 +1,13 @@
 package config
 
+import "time"
+
 const (
-	DefaultServerAddress = "localhost:8080"
+	DefaultServerAddress   = "localhost:8080"
+	DefaultShutdownTimeout = 10 * time.Second
 )
 
 type ServerConfig struct {
-	Address string
+	Address         string
+	ShutdownTimeout time.Duration
 }
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/ledger"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/money"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/order"
+)
+
+const (
+	TextNoDeadJobError = "There is no dead-lettered or quarantined accrual job for this order"
+)
+
+func (rh *RepositorieHandler) DeadAccrualJobs(w http.ResponseWriter, r *http.Request) {
+	log := rh.Logger.LogrusLog
+
+	jobs, err := rh.Repo.DeadAccrualJobs(r.Context())
+	if err != nil {
+		log.Errorf("failed get dead accrual jobs: %v", err)
+		http.Error(w, TextServerError, http.StatusInternalServerError)
+		return
+	}
+
+	if len(jobs) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set(ContentType, ContentTypeJSON)
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(jobs); err != nil {
+		log.Errorf("error encode dead accrual jobs - %v", err)
+		http.Error(w, TextServerError, http.StatusInternalServerError)
+		return
+	}
+}
+
+func (rh *RepositorieHandler) QuarantinedAccrualJobs(w http.ResponseWriter, r *http.Request) {
+	log := rh.Logger.LogrusLog
+
+	jobs, err := rh.Repo.QuarantinedAccrualJobs(r.Context())
+	if err != nil {
+		log.Errorf("failed get quarantined accrual jobs: %v", err)
+		http.Error(w, TextServerError, http.StatusInternalServerError)
+		return
+	}
+
+	if len(jobs) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set(ContentType, ContentTypeJSON)
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(jobs); err != nil {
+		log.Errorf("error encode quarantined accrual jobs - %v", err)
+		http.Error(w, TextServerError, http.StatusInternalServerError)
+		return
+	}
+}
+
+func (rh *RepositorieHandler) RequeueAccrualJob(w http.ResponseWriter, r *http.Request) {
+	log := rh.Logger.LogrusLog
+
+	err := rh.Repo.RequeueAccrualJob(r.Context(), chi.URLParam(r, "number"))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, TextNoDeadJobError, http.StatusNotFound)
+			return
+		}
+		log.Errorf("failed requeue accrual job: %v", err)
+		http.Error(w, TextServerError, http.StatusInternalServerError)
+		return
+	}
+
+	rh.pool.Notify()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// CloseAccrualJob gives up on a dead-lettered or quarantined order: the order becomes
+// INVALID without any accrual and its job is removed.
+func (rh *RepositorieHandler) CloseAccrualJob(w http.ResponseWriter, r *http.Request) {
+	log := rh.Logger.LogrusLog
+
+	job, err := rh.Repo.GetAccrualJob(r.Context(), chi.URLParam(r, "number"))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, TextNoDeadJobError, http.StatusNotFound)
+			return
+		}
+		log.Errorf("failed get accrual job: %v", err)
+		http.Error(w, TextServerError, http.StatusInternalServerError)
+		return
+	}
+
+	if !job.Parked() {
+		http.Error(w, TextNoDeadJobError, http.StatusNotFound)
+		return
+	}
+
+	orderData := job.Order
+	orderData.Status = order.StatusInvalid
+
+	err = rh.Repo.ProcessingOrder(r.Context(), orderData)
+	if err != nil {
+		log.Errorf("failed close accrual job: %v", err)
+		http.Error(w, TextServerError, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+type adjustRequest struct {
+	Comment string       `json:"comment"`
+	Amount  money.Amount `json:"amount"`
+}
+
+// AdjustBalance credits or, with a negative amount, debits the user
+// account by a manual ledger posting.
+func (rh *RepositorieHandler) AdjustBalance(w http.ResponseWriter, r *http.Request) {
+	log := rh.Logger.LogrusLog
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		http.Error(w, TextInvalidFormatError, http.StatusBadRequest)
+		return
+	}
+
+	req := adjustRequest{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil || req.Amount == 0 || req.Comment == "" {
+		http.Error(w, TextInvalidFormatError, http.StatusBadRequest)
+		return
+	}
+
+	err = rh.Repo.AdjustBalance(r.Context(), userID, req.Amount, req.Comment)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			http.Error(w, "There is no accaunt of this user", http.StatusNotFound)
+			return
+		case errors.Is(err, ledger.ErrInsufficientFunds):
+			http.Error(w, TextFewPointsError, http.StatusPaymentRequired)
+			return
+		}
+		log.Errorf("failed adjust balance: %v", err)
+		http.Error(w, TextServerError, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
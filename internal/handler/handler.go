@@ -2,14 +2,19 @@ package handler
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 
 	"github.com/zhenyanesterkova/gmloyalty/internal/config"
 	"github.com/zhenyanesterkova/gmloyalty/internal/middleware"
 	"github.com/zhenyanesterkova/gmloyalty/internal/myclient"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/idempotency"
 	"github.com/zhenyanesterkova/gmloyalty/internal/service/logger"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/money"
 	"github.com/zhenyanesterkova/gmloyalty/internal/service/order"
 	"github.com/zhenyanesterkova/gmloyalty/internal/service/session"
 	"github.com/zhenyanesterkova/gmloyalty/internal/service/user"
@@ -24,12 +29,17 @@ const (
 	TextInvalidFormatError  = "Invalid request format"
 	TextNoContentError      = "There is no order with this number"
 	TextConflictUserIDError = "The order number has already been uploaded by another user"
-	CountWorkersInPool      = 20
+	TextQueueFullError      = "Too many orders in processing, try again later"
 	ContentTypeText         = "text/plain"
 	ContentTypeJSON         = "application/json"
 	ContentType             = "Content-Type"
 )
 
+const (
+	idempotencyPurgeInterval = time.Hour
+	holdReleaseInterval      = time.Minute
+)
+
 type Repositorie interface {
 	Ping() error
 	Close() error
@@ -43,6 +53,34 @@ type Repositorie interface {
 	GetUserAccaunt(userID int) (user.Accaunt, error)
 	Withdraw(ctx context.Context, userID int, withdrawInst order.Withdraw) error
 	Withdrawals(ctx context.Context, userID int) ([]order.Withdraw, error)
+	ClaimAccrualJobs(ctx context.Context, owner string, lease time.Duration, limit int) ([]order.AccrualJob, error)
+	ReleaseAccrualJob(ctx context.Context, owner string, job order.AccrualJob) error
+	RenewAccrualJobLease(ctx context.Context, owner string, orderNum string, lease time.Duration) (bool, error)
+	ResetAccrualJobs(ctx context.Context, owner string) error
+	DeadLetterAccrualJob(ctx context.Context, owner string, job order.AccrualJob) error
+	GetAccrualJob(ctx context.Context, orderNum string) (order.AccrualJob, error)
+	DeadAccrualJobs(ctx context.Context) ([]order.AccrualJob, error)
+	RequeueAccrualJob(ctx context.Context, orderNum string) error
+	QuarantineAccrualJob(ctx context.Context, orderNum, reason string) error
+	QuarantinedAccrualJobs(ctx context.Context) ([]order.AccrualJob, error)
+	AdjustBalance(ctx context.Context, userID int, amount money.Amount, comment string) error
+	ExpirePoints(ctx context.Context) (int, error)
+	HoldPoints(ctx context.Context, userID int, hold order.Hold, timeout time.Duration) (order.Hold, error)
+	CaptureHold(ctx context.Context, userID int, orderNum string) (order.Hold, error)
+	ReleaseHold(ctx context.Context, userID int, orderNum string) (order.Hold, error)
+	ReleaseExpiredHolds(ctx context.Context) (int, error)
+	RefundWithdrawal(
+		ctx context.Context,
+		orderNum, merchant string,
+		sum money.Amount,
+		comment string,
+	) (order.Withdraw, error)
+	ReserveIdempotencyKey(ctx context.Context, key idempotency.Key, ttl time.Duration) (idempotency.Record, bool, error)
+	SaveIdempotentResponse(ctx context.Context, key idempotency.Key, resp idempotency.Response) error
+	ReleaseIdempotencyKey(ctx context.Context, key idempotency.Key) error
+	PurgeIdempotencyKeys(ctx context.Context) (int64, error)
+	RecordWebhookDelivery(ctx context.Context, signature string, ttl time.Duration) (bool, error)
+	PurgeWebhookDeliveries(ctx context.Context) (int64, error)
 }
 
 type RepositorieHandler struct {
@@ -50,48 +88,198 @@ type RepositorieHandler struct {
 	Logger  logger.LogrusLogger
 	pool    *wpool.WorkerPool
 	jwtSess *session.SessionsJWT
+	accrual *myclient.Router
+
+	webhookSecret  []byte
+	adminToken     string
+	merchantTokens map[string]string
+	idemSecret     []byte
+	idemTTL        time.Duration
+	expiryInterval time.Duration
+	holdTimeout    time.Duration
+}
+
+type healthStatus struct {
+	AccrualProviders map[string]string `json:"accrual_providers"`
+	Storage          string            `json:"storage"`
+	AccrualCircuit   string            `json:"accrual_circuit"`
+	AccrualQueue     wpool.QueueStats  `json:"accrual_queue"`
+	AccrualWorkers   int               `json:"accrual_workers"`
 }
 
 func NewRepositorieHandler(
 	rep Repositorie,
 	log logger.LogrusLogger,
 	cfgJWT config.JWTConfig,
-	accrualAddress string,
-) *RepositorieHandler {
+	cfgClient config.CliConfig,
+	cfgPool config.PoolConfig,
+	cfgBreaker config.BreakerConfig,
+	cfgAdmin config.AdminConfig,
+	cfgIdem config.IdempotencyConfig,
+	cfgExpiry config.ExpiryConfig,
+	cfgHold config.HoldConfig,
+) (*RepositorieHandler, error) {
 	jwtSession := session.NewSessionsJWT(cfgJWT)
-	acc := myclient.Accrual(accrualAddress)
+	acc, err := myclient.NewRouter(cfgClient, cfgBreaker, log)
+	if err != nil {
+		return nil, fmt.Errorf("failed create accrual client: %w", err)
+	}
 	pool := wpool.New(
 		rep,
 		log,
 		acc,
-		CountWorkersInPool,
+		cfgPool,
 	)
 	return &RepositorieHandler{
 		Repo:    rep,
 		Logger:  log,
 		jwtSess: jwtSession,
 		pool:    pool,
+		accrual: acc,
+
+		webhookSecret:  []byte(cfgClient.WebhookSecret),
+		adminToken:     cfgAdmin.Token,
+		merchantTokens: cfgAdmin.MerchantTokens,
+		idemSecret:     []byte(cfgJWT.SecretKey),
+		idemTTL:        cfgIdem.TTL,
+		expiryInterval: cfgExpiry.Interval,
+		holdTimeout:    cfgHold.Timeout,
+	}, nil
+}
+
+// RunPool processes pending accrual jobs, purges expired idempotency
+// keys and webhook deliveries, expires points and releases expired holds
+// until ctx is canceled.
+func (rh *RepositorieHandler) RunPool(ctx context.Context) {
+	go rh.purgeIdempotencyKeys(ctx)
+	if len(rh.webhookSecret) != 0 {
+		go rh.purgeWebhookDeliveries(ctx)
+	}
+	go rh.expirePoints(ctx)
+	go rh.releaseExpiredHolds(ctx)
+	rh.pool.Start(ctx)
+}
+
+func (rh *RepositorieHandler) releaseExpiredHolds(ctx context.Context) {
+	log := rh.Logger.LogrusLog
+
+	ticker := time.NewTicker(holdReleaseInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			released, err := rh.Repo.ReleaseExpiredHolds(ctx)
+			if err != nil {
+				log.Errorf("failed release expired holds: %v", err)
+				continue
+			}
+			if released > 0 {
+				log.Infof("released %d expired holds", released)
+			}
+		}
+	}
+}
+
+func (rh *RepositorieHandler) expirePoints(ctx context.Context) {
+	log := rh.Logger.LogrusLog
+
+	ticker := time.NewTicker(rh.expiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expired, err := rh.Repo.ExpirePoints(ctx)
+			if err != nil {
+				log.Errorf("failed expire points: %v", err)
+				continue
+			}
+			if expired > 0 {
+				log.Infof("expired %d lots of points", expired)
+			}
+		}
+	}
+}
+
+func (rh *RepositorieHandler) purgeIdempotencyKeys(ctx context.Context) {
+	log := rh.Logger.LogrusLog
+
+	ticker := time.NewTicker(idempotencyPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := rh.Repo.PurgeIdempotencyKeys(ctx)
+			if err != nil {
+				log.Errorf("failed purge expired idempotency keys: %v", err)
+				continue
+			}
+			if purged > 0 {
+				log.Debugf("purged %d expired idempotency keys", purged)
+			}
+		}
 	}
 }
 
 func (rh *RepositorieHandler) InitChiRouter(router *chi.Mux) {
-	go rh.pool.Start(context.TODO())
-	mdlWare := middleware.NewMiddlewareStruct(rh.Logger, rh.jwtSess)
+	mdlWare := middleware.NewMiddlewareStruct(
+		rh.Logger,
+		rh.jwtSess,
+		rh.adminToken,
+		rh.merchantTokens,
+		rh.Repo,
+		rh.idemSecret,
+		rh.idemTTL,
+	)
 	router.Use(mdlWare.ResetRespDataStruct)
 	router.Use(mdlWare.RequestLogger)
 	router.Use(mdlWare.Auth)
 	router.Use(mdlWare.GZipMiddleware)
 	router.Route("/", func(r chi.Router) {
 		r.Get("/ping", rh.Ping)
+		r.Get("/health", rh.Health)
 		r.Route("/api/user/", func(r chi.Router) {
-			r.Post("/register", rh.Register)
+			r.With(mdlWare.Idempotency).Post("/register", rh.Register)
 			r.Post("/login", rh.Login)
-			r.Post("/orders", rh.Orders)
+			r.With(mdlWare.Idempotency).Post("/orders", rh.Orders)
 			r.Get("/orders", rh.GetOrderList)
 			r.Get("/balance", rh.GetBalance)
-			r.Post("/balance/withdraw", rh.Withdraw)
+			r.With(mdlWare.Idempotency).Post("/balance/withdraw", rh.Withdraw)
+			r.With(mdlWare.Idempotency).Post("/balance/hold", rh.HoldPoints)
+			r.With(mdlWare.Idempotency).Post("/balance/hold/{number}/capture", rh.CaptureHold)
+			r.With(mdlWare.Idempotency).Post("/balance/hold/{number}/release", rh.ReleaseHold)
 			r.Get("/withdrawals", rh.GetWithdrawals)
 		})
+		if len(rh.webhookSecret) != 0 {
+			r.Post("/api/accrual/callback", rh.AccrualCallback)
+		}
+		if rh.adminToken != "" {
+			r.Route("/api/admin/", func(r chi.Router) {
+				r.Use(mdlWare.AdminAuth)
+				r.Get("/accrual/dead", rh.DeadAccrualJobs)
+				r.Post("/accrual/dead/{number}/requeue", rh.RequeueAccrualJob)
+				r.Post("/accrual/dead/{number}/close", rh.CloseAccrualJob)
+				r.Get("/accrual/quarantine", rh.QuarantinedAccrualJobs)
+				r.Post("/accrual/quarantine/{number}/requeue", rh.RequeueAccrualJob)
+				r.Post("/accrual/quarantine/{number}/close", rh.CloseAccrualJob)
+				r.Post("/accounts/{userID}/adjust", rh.AdjustBalance)
+				r.With(mdlWare.Idempotency).Post("/withdrawals/{number}/refund", rh.RefundWithdrawal)
+			})
+		}
+		if len(rh.merchantTokens) != 0 {
+			r.Route("/api/merchant/", func(r chi.Router) {
+				r.Use(mdlWare.MerchantAuth)
+				r.With(mdlWare.Idempotency).Post("/withdrawals/{number}/refund", rh.RefundWithdrawal)
+			})
+		}
 	})
 }
 
@@ -106,3 +294,32 @@ func (rh *RepositorieHandler) Ping(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+func (rh *RepositorieHandler) Health(w http.ResponseWriter, r *http.Request) {
+	log := rh.Logger.LogrusLog
+
+	status := healthStatus{
+		Storage:          "ok",
+		AccrualCircuit:   rh.accrual.State(),
+		AccrualProviders: rh.accrual.States(),
+		AccrualWorkers:   rh.pool.Workers(),
+		AccrualQueue:     rh.pool.QueueStats(),
+	}
+	code := http.StatusOK
+
+	err := rh.Repo.Ping()
+	if err != nil {
+		log.Errorf("failed ping storage: %v", err)
+		status.Storage = "unavailable"
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set(ContentType, ContentTypeJSON)
+	w.WriteHeader(code)
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(status); err != nil {
+		log.Errorf("error encode health status - %v", err)
+		return
+	}
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/helper"
+	"github.com/zhenyanesterkova/gmloyalty/internal/middleware"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/ledger"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/order"
+)
+
+const (
+	TextOrderExistsError   = "The order number has already been used"
+	TextNoHoldError        = "There is no hold for this order"
+	TextHoldNotActiveError = "The hold has already been captured or released"
+	TextHoldExpiredError   = "The hold has expired and has been released"
+)
+
+// HoldPoints reserves points of the user for the order at checkout.
+func (rh *RepositorieHandler) HoldPoints(w http.ResponseWriter, r *http.Request) {
+	log := rh.Logger.LogrusLog
+	userID, ok := r.Context().Value(middleware.UserIDContextKey).(int)
+	if !ok {
+		http.Error(w, TextNoAuthError, http.StatusUnauthorized)
+		return
+	}
+
+	hold := order.Hold{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&hold); err != nil {
+		http.Error(w, TextInvalidFormatError, http.StatusBadRequest)
+		return
+	}
+
+	if !helper.LuhnCheck(hold.Number) {
+		http.Error(w, "incorrect order number format", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if hold.Sum <= 0 {
+		http.Error(w, "sum of hold must be positive", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if !rh.knownMerchant(hold.Merchant) {
+		http.Error(w, TextUnknownMerchantError, http.StatusUnprocessableEntity)
+		return
+	}
+
+	hold, err := rh.Repo.HoldPoints(r.Context(), userID, hold, rh.holdTimeout)
+	if err != nil {
+		switch {
+		case errors.Is(err, ledger.ErrInsufficientFunds):
+			http.Error(w, TextFewPointsError, http.StatusPaymentRequired)
+			return
+		case errors.Is(err, order.ErrOrderExists):
+			http.Error(w, TextOrderExistsError, http.StatusConflict)
+			return
+		}
+		log.Errorf("failed hold points: %v", err)
+		http.Error(w, TextServerError, http.StatusInternalServerError)
+		return
+	}
+
+	rh.writeHold(w, hold)
+}
+
+// CaptureHold turns the hold into a withdrawal once the payment clears.
+func (rh *RepositorieHandler) CaptureHold(w http.ResponseWriter, r *http.Request) {
+	rh.finishHold(w, r, rh.Repo.CaptureHold)
+}
+
+// ReleaseHold returns the held points to the available balance.
+func (rh *RepositorieHandler) ReleaseHold(w http.ResponseWriter, r *http.Request) {
+	rh.finishHold(w, r, rh.Repo.ReleaseHold)
+}
+
+func (rh *RepositorieHandler) finishHold(
+	w http.ResponseWriter,
+	r *http.Request,
+	finish func(ctx context.Context, userID int, orderNum string) (order.Hold, error),
+) {
+	log := rh.Logger.LogrusLog
+	userID, ok := r.Context().Value(middleware.UserIDContextKey).(int)
+	if !ok {
+		http.Error(w, TextNoAuthError, http.StatusUnauthorized)
+		return
+	}
+
+	hold, err := finish(r.Context(), userID, chi.URLParam(r, "number"))
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			http.Error(w, TextNoHoldError, http.StatusNotFound)
+			return
+		case errors.Is(err, order.ErrHoldNotActive):
+			http.Error(w, TextHoldNotActiveError, http.StatusConflict)
+			return
+		case errors.Is(err, order.ErrHoldExpired):
+			http.Error(w, TextHoldExpiredError, http.StatusGone)
+			return
+		case errors.Is(err, ledger.ErrInsufficientFunds):
+			http.Error(w, TextFewPointsError, http.StatusPaymentRequired)
+			return
+		}
+		log.Errorf("failed finish hold: %v", err)
+		http.Error(w, TextServerError, http.StatusInternalServerError)
+		return
+	}
+
+	rh.writeHold(w, hold)
+}
+
+func (rh *RepositorieHandler) writeHold(w http.ResponseWriter, hold order.Hold) {
+	w.Header().Set(ContentType, ContentTypeJSON)
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(hold); err != nil {
+		rh.Logger.LogrusLog.Errorf("error encode hold - %v", err)
+		return
+	}
+}
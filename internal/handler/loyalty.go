@@ -4,13 +4,17 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/jackc/pgx/v5"
 
 	"github.com/zhenyanesterkova/gmloyalty/internal/helper"
 	"github.com/zhenyanesterkova/gmloyalty/internal/middleware"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/ledger"
 	"github.com/zhenyanesterkova/gmloyalty/internal/service/order"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/wpool"
 )
 
 func (rh *RepositorieHandler) Orders(w http.ResponseWriter, r *http.Request) {
@@ -49,6 +53,14 @@ func (rh *RepositorieHandler) Orders(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		var errQueueFull *wpool.QueueFullError
+		if errors.As(rh.pool.Admit(), &errQueueFull) {
+			log.Warnf("reject order %s: %v", orderNum, errQueueFull)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(errQueueFull.RetryAfter.Seconds()))))
+			http.Error(w, TextQueueFullError, http.StatusServiceUnavailable)
+			return
+		}
+
 		orderData.Status = order.StatusNew
 		orderData.Number = orderNum
 		orderData.UserID = userID
@@ -60,17 +72,12 @@ func (rh *RepositorieHandler) Orders(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		rh.pool.Queue <- orderData
+		rh.pool.Notify()
 
 		w.WriteHeader(http.StatusAccepted)
 		return
 	}
 
-	if orderData.Status == order.StatusNew ||
-		orderData.Status == order.StatusProcessing {
-		rh.pool.Queue <- orderData
-	}
-
 	if orderData.UserID != userID {
 		http.Error(w, TextConflictUserIDError, http.StatusConflict)
 		return
@@ -161,20 +168,26 @@ func (rh *RepositorieHandler) Withdraw(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	accaunt, err := rh.Repo.GetUserAccaunt(userID)
-	if err != nil {
-		log.Errorf("failed get user accaunt: %v", err)
-		http.Error(w, TextServerError, http.StatusInternalServerError)
+	if withdraw.Sum <= 0 {
+		http.Error(w, "sum of withdraw must be positive", http.StatusUnprocessableEntity)
 		return
 	}
 
-	if accaunt.Balance < withdraw.Sum {
-		http.Error(w, TextFewPointsError, http.StatusPaymentRequired)
+	if !rh.knownMerchant(withdraw.Merchant) {
+		http.Error(w, TextUnknownMerchantError, http.StatusUnprocessableEntity)
 		return
 	}
 
-	err = rh.Repo.Withdraw(r.Context(), userID, withdraw)
+	err := rh.Repo.Withdraw(r.Context(), userID, withdraw)
 	if err != nil {
+		if errors.Is(err, ledger.ErrInsufficientFunds) {
+			http.Error(w, TextFewPointsError, http.StatusPaymentRequired)
+			return
+		}
+		if errors.Is(err, order.ErrOrderExists) {
+			http.Error(w, TextOrderExistsError, http.StatusConflict)
+			return
+		}
 		log.Errorf("failed withdraw %v", err)
 		http.Error(w, TextServerError, http.StatusInternalServerError)
 		return
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/middleware"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/ledger"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/money"
+)
+
+const (
+	TextNoWithdrawalError      = "There is no withdrawal for this order"
+	TextRefundExceededError    = "Refund exceeds the rest of the withdrawal"
+	TextNegativeRefundSumError = "Refund sum must be positive"
+	TextUnknownMerchantError   = "Unknown merchant"
+)
+
+// refundRequest is the body of a refund. Zero Sum refunds
+// all the rest of the withdrawal.
+type refundRequest struct {
+	Comment string       `json:"comment"`
+	Sum     money.Amount `json:"sum"`
+}
+
+// RefundWithdrawal returns all or a part of the withdrawal for the order
+// back to the user account. It is called by admins and merchant integrations,
+// a merchant may refund only the withdrawals made at it.
+func (rh *RepositorieHandler) RefundWithdrawal(w http.ResponseWriter, r *http.Request) {
+	log := rh.Logger.LogrusLog
+
+	orderNum := chi.URLParam(r, "number")
+
+	req := refundRequest{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, TextInvalidFormatError, http.StatusBadRequest)
+		return
+	}
+	if req.Sum < 0 {
+		http.Error(w, TextNegativeRefundSumError, http.StatusUnprocessableEntity)
+		return
+	}
+
+	// empty for admins
+	merchant, _ := r.Context().Value(middleware.MerchantContextKey).(string)
+
+	withdraw, err := rh.Repo.RefundWithdrawal(r.Context(), orderNum, merchant, req.Sum, req.Comment)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			http.Error(w, TextNoWithdrawalError, http.StatusNotFound)
+			return
+		case errors.Is(err, ledger.ErrRefundExceeded):
+			http.Error(w, TextRefundExceededError, http.StatusUnprocessableEntity)
+			return
+		}
+		log.Errorf("failed refund withdrawal: %v", err)
+		http.Error(w, TextServerError, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(ContentType, ContentTypeJSON)
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(withdraw); err != nil {
+		log.Errorf("error encode refunded withdrawal - %v", err)
+		return
+	}
+}
+
+// knownMerchant reports whether the merchant a withdrawal is made at is
+// configured. Withdrawals without a merchant are refunded by admins only.
+func (rh *RepositorieHandler) knownMerchant(merchant string) bool {
+	if merchant == "" {
+		return true
+	}
+	_, ok := rh.merchantTokens[merchant]
+	return ok
+}
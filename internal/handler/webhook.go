@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/myclient"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/money"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/order"
+)
+
+const (
+	SignatureHeader    = "X-Signature"
+	TimestampHeader    = "X-Timestamp"
+	signaturePrefix    = "sha256="
+	maxCallbackBodyLen = 1 << 20
+	// callbackTolerance is how far the timestamp of a callback may be
+	// from the server time. Seen signatures are kept for twice as long.
+	callbackTolerance = 5 * time.Minute
+)
+
+type accrualCallback struct {
+	Number  string       `json:"order"`
+	Status  string       `json:"status"`
+	Accrual money.Amount `json:"accrual"`
+}
+
+// AccrualCallback accepts order results pushed by the accrual system.
+// The timestamp header and the body must be signed with HMAC-SHA256 using
+// the shared secret. Stale callbacks and replays of a delivered callback
+// are rejected. Repeated callbacks for a processed order are accepted
+// and ignored.
+func (rh *RepositorieHandler) AccrualCallback(w http.ResponseWriter, r *http.Request) {
+	log := rh.Logger.LogrusLog
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCallbackBodyLen))
+	if err != nil {
+		http.Error(w, TextInvalidFormatError, http.StatusBadRequest)
+		return
+	}
+
+	timestamp := r.Header.Get(TimestampHeader)
+	signature, ok := rh.validSignature(timestamp, body, r.Header.Get(SignatureHeader))
+	if !ok {
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	if !freshTimestamp(timestamp, time.Now()) {
+		http.Error(w, "Callback is stale", http.StatusUnauthorized)
+		return
+	}
+
+	fresh, err := rh.Repo.RecordWebhookDelivery(r.Context(), signature, 2*callbackTolerance)
+	if err != nil {
+		log.Errorf("failed record accrual callback delivery: %v", err)
+		http.Error(w, TextServerError, http.StatusInternalServerError)
+		return
+	}
+	if !fresh {
+		http.Error(w, "Callback has already been delivered", http.StatusConflict)
+		return
+	}
+
+	callback := accrualCallback{}
+	if err := json.Unmarshal(body, &callback); err != nil || callback.Number == "" {
+		http.Error(w, TextInvalidFormatError, http.StatusBadRequest)
+		return
+	}
+
+	err = myclient.ValidateOrder(callback.Number, order.Order{
+		Number:  callback.Number,
+		Status:  callback.Status,
+		Accrual: callback.Accrual,
+	})
+	if err != nil {
+		log.Warnf("quarantine order %s after invalid accrual callback: %v", callback.Number, err)
+		errQuarantine := rh.Repo.QuarantineAccrualJob(r.Context(), callback.Number, err.Error())
+		if errQuarantine != nil && !errors.Is(errQuarantine, pgx.ErrNoRows) {
+			log.Errorf("failed quarantine accrual job: %v", errQuarantine)
+		}
+		http.Error(w, TextInvalidFormatError, http.StatusUnprocessableEntity)
+		return
+	}
+
+	status, err := order.FromAccrualStatus(callback.Status)
+	if err != nil {
+		http.Error(w, TextInvalidFormatError, http.StatusBadRequest)
+		return
+	}
+
+	orderData, err := rh.Repo.GetOrderByOrderNum(callback.Number)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, TextNoContentError, http.StatusNotFound)
+			return
+		}
+		log.Errorf("failed get order for accrual callback: %v", err)
+		http.Error(w, TextServerError, http.StatusInternalServerError)
+		return
+	}
+
+	if order.IsFinal(orderData.Status) || !order.CanTransition(orderData.Status, status) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	orderData.Status = status
+	if !order.IsFinal(status) {
+		err = rh.Repo.UpdateOrderStatus(orderData)
+	} else {
+		orderData.Accrual = callback.Accrual
+		orderData.Provider = rh.accrual.Route(callback.Number)
+		err = rh.Repo.ProcessingOrder(r.Context(), orderData)
+	}
+	if err != nil {
+		log.Errorf("failed apply accrual callback: %v", err)
+		http.Error(w, TextServerError, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// validSignature checks the signature of "<timestamp>.<body>" and returns
+// it hex encoded to tell repeated deliveries apart.
+func (rh *RepositorieHandler) validSignature(timestamp string, body []byte, signature string) (string, bool) {
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, signaturePrefix))
+	if err != nil || len(got) == 0 || timestamp == "" {
+		return "", false
+	}
+
+	mac := hmac.New(sha256.New, rh.webhookSecret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+
+	want := mac.Sum(nil)
+	if !hmac.Equal(got, want) {
+		return "", false
+	}
+	return hex.EncodeToString(want), true
+}
+
+// freshTimestamp reports whether the unix timestamp in seconds is within
+// callbackTolerance of now.
+func freshTimestamp(timestamp string, now time.Time) bool {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	diff := now.Sub(time.Unix(sec, 0))
+	return diff <= callbackTolerance && diff >= -callbackTolerance
+}
+
+func (rh *RepositorieHandler) purgeWebhookDeliveries(ctx context.Context) {
+	log := rh.Logger.LogrusLog
+
+	ticker := time.NewTicker(idempotencyPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := rh.Repo.PurgeWebhookDeliveries(ctx)
+			if err != nil {
+				log.Errorf("failed purge expired webhook deliveries: %v", err)
+				continue
+			}
+			if purged > 0 {
+				log.Debugf("purged %d expired webhook deliveries", purged)
+			}
+		}
+	}
+}
@@ -2,20 +2,34 @@ package middleware
 
 import (
 	"context"
+	"crypto/subtle"
 	"net/http"
+	"strings"
 )
 
 type contextKey uint
 
 const (
 	UserIDContextKey contextKey = iota
+	MerchantContextKey
 )
 
 var (
 	noAuthUrls = map[string]struct{}{
-		"/api/user/register": {},
-		"/api/user/login":    {},
+		"/api/user/register":    {},
+		"/api/user/login":       {},
+		"/health":               {},
+		"/api/accrual/callback": {},
 	}
+	noAuthPrefixes = []string{
+		"/api/admin/",
+		"/api/merchant/",
+	}
+)
+
+const (
+	AdminTokenHeader    = "X-Admin-Token"
+	MerchantTokenHeader = "X-Merchant-Token"
 )
 
 func (lm MiddlewareStruct) Auth(next http.Handler) http.Handler {
@@ -24,6 +38,12 @@ func (lm MiddlewareStruct) Auth(next http.Handler) http.Handler {
 			next.ServeHTTP(w, r)
 			return
 		}
+		for _, prefix := range noAuthPrefixes {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
 
 		tokenJWT := r.Header.Get("Authorization")
 
@@ -42,3 +62,37 @@ func (lm MiddlewareStruct) Auth(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+func (lm MiddlewareStruct) AdminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(AdminTokenHeader)
+		if lm.adminToken == "" ||
+			subtle.ConstantTimeCompare([]byte(token), []byte(lm.adminToken)) != 1 {
+			http.Error(w, "No auth", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// MerchantAuth finds the merchant by its token and puts its name
+// into the request context.
+func (lm MiddlewareStruct) MerchantAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(MerchantTokenHeader)
+
+		merchant := ""
+		for name, merchantToken := range lm.merchantTokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(merchantToken)) == 1 {
+				merchant = name
+			}
+		}
+		if token == "" || merchant == "" {
+			http.Error(w, "No auth", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), MerchantContextKey, merchant)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,195 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/idempotency"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotentReplayedHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	textIdempotencyKeyError   = "Invalid Idempotency-Key"
+	textIdempotencyReuseError = "Idempotency-Key is already used for another request"
+	textIdempotencyBusyError  = "Request with this Idempotency-Key is still in progress"
+)
+
+// replayedHeaders are the response headers stored with the response
+// and sent again on retries. Credentials must never be stored.
+var replayedHeaders = []string{
+	"Content-Type",
+	"Retry-After",
+	"Location",
+}
+
+type IdempotencyStore interface {
+	ReserveIdempotencyKey(ctx context.Context, key idempotency.Key, ttl time.Duration) (idempotency.Record, bool, error)
+	SaveIdempotentResponse(ctx context.Context, key idempotency.Key, resp idempotency.Response) error
+	ReleaseIdempotencyKey(ctx context.Context, key idempotency.Key) error
+}
+
+type recordingWriter struct {
+	http.ResponseWriter
+	body   bytes.Buffer
+	status int
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	rw.body.Write(b)
+	size, err := rw.ResponseWriter.Write(b)
+	if err != nil {
+		return size, fmt.Errorf("idempotency.go Write() - %w", err)
+	}
+	return size, nil
+}
+
+func (rw *recordingWriter) WriteHeader(statusCode int) {
+	if rw.status == 0 {
+		rw.status = statusCode
+	}
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Idempotency replays the first response to the request with the same
+// Idempotency-Key header. Requests without the header are handled as usual.
+// A key is bound to the user, the path and the body of the first request.
+// Responses with 5xx status are not stored, so that the retry is handled again.
+func (lm MiddlewareStruct) Idempotency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := lm.Logger.LogrusLog
+
+		value := r.Header.Get(IdempotencyKeyHeader)
+		if value == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(value) > maxIdempotencyKeyLength {
+			http.Error(w, textIdempotencyKeyError, http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Errorf("failed read body for idempotency key: %v", err)
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		userID, _ := r.Context().Value(UserIDContextKey).(int)
+		key := idempotency.Key{
+			UserID:      userID,
+			Path:        r.URL.Path,
+			Value:       value,
+			RequestHash: idempotency.Hash(lm.idemSecret, r.Method, r.URL.Path, body),
+		}
+		// keys of merchants are scoped by the merchant, other anonymous
+		// keys by the request
+		merchant, _ := r.Context().Value(MerchantContextKey).(string)
+		switch {
+		case userID != 0:
+		case merchant != "":
+			key.Scope = idempotency.Hash(lm.idemSecret, "", "", []byte(merchant))
+		default:
+			key.Scope = key.RequestHash
+		}
+
+		record, reserved, err := lm.idemStore.ReserveIdempotencyKey(r.Context(), key, lm.idemTTL)
+		if err != nil {
+			log.Errorf("failed reserve idempotency key: %v", err)
+			http.Error(w, "Something went wrong... Server error", http.StatusInternalServerError)
+			return
+		}
+
+		if !reserved {
+			switch {
+			case record.RequestHash != key.RequestHash:
+				http.Error(w, textIdempotencyReuseError, http.StatusUnprocessableEntity)
+			case record.Response == nil:
+				http.Error(w, textIdempotencyBusyError, http.StatusConflict)
+			default:
+				if err := lm.replay(w, record.Response); err != nil {
+					log.Errorf("failed replay idempotent response: %v", err)
+				}
+			}
+			return
+		}
+
+		rw := &recordingWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+
+		// the response is already sent, the client should not wait for the key to be stored
+		ctx := context.WithoutCancel(r.Context())
+
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+		}
+		if rw.status >= http.StatusInternalServerError {
+			err = lm.idemStore.ReleaseIdempotencyKey(ctx, key)
+			if err != nil {
+				log.Errorf("failed release idempotency key: %v", err)
+			}
+			return
+		}
+
+		header := http.Header{}
+		for _, name := range replayedHeaders {
+			if v := rw.Header().Values(name); len(v) != 0 {
+				header[name] = v
+			}
+		}
+		resp := idempotency.Response{
+			StatusCode: rw.status,
+			Header:     header,
+			Body:       rw.body.Bytes(),
+		}
+		// keep whom the token was issued for, not the token itself
+		if token := rw.Header().Get("Authorization"); token != "" {
+			resp.Subject, err = lm.jwtSess.Check(token)
+			if err != nil {
+				log.Errorf("failed check issued token for idempotency key: %v", err)
+			}
+		}
+		err = lm.idemStore.SaveIdempotentResponse(ctx, key, resp)
+		if err != nil {
+			log.Errorf("failed save idempotent response: %v", err)
+			// do not leave the key in progress until it expires
+			err = lm.idemStore.ReleaseIdempotencyKey(ctx, key)
+			if err != nil {
+				log.Errorf("failed release idempotency key: %v", err)
+			}
+		}
+	})
+}
+
+func (lm MiddlewareStruct) replay(w http.ResponseWriter, resp *idempotency.Response) error {
+	for name, values := range resp.Header {
+		for _, v := range values {
+			w.Header().Add(name, v)
+		}
+	}
+	if resp.Subject != 0 {
+		token, err := lm.jwtSess.Create(resp.Subject)
+		if err != nil {
+			http.Error(w, "Something went wrong... Server error", http.StatusInternalServerError)
+			return fmt.Errorf("failed create token for replayed response: %w", err)
+		}
+		w.Header().Set("Authorization", token)
+	}
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(resp.StatusCode)
+	_, err := w.Write(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed write replayed response: %w", err)
+	}
+	return nil
+}
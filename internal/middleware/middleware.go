@@ -2,18 +2,33 @@ package middleware
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/zhenyanesterkova/gmloyalty/internal/service/logger"
 	"github.com/zhenyanesterkova/gmloyalty/internal/service/session"
 )
 
 type MiddlewareStruct struct {
-	Logger   logger.LogrusLogger
-	respData *responseDataWriter
-	jwtSess  *session.SessionsJWT
+	Logger     logger.LogrusLogger
+	respData   *responseDataWriter
+	jwtSess    *session.SessionsJWT
+	adminToken string
+	// merchantTokens authenticate trusted merchant integrations by name
+	merchantTokens map[string]string
+	idemStore      IdempotencyStore
+	idemSecret     []byte
+	idemTTL        time.Duration
 }
 
-func NewMiddlewareStruct(log logger.LogrusLogger, jwtSess *session.SessionsJWT) MiddlewareStruct {
+func NewMiddlewareStruct(
+	log logger.LogrusLogger,
+	jwtSess *session.SessionsJWT,
+	adminToken string,
+	merchantTokens map[string]string,
+	idemStore IdempotencyStore,
+	idemSecret []byte,
+	idemTTL time.Duration,
+) MiddlewareStruct {
 	responseData := &responseData{
 		status: 0,
 		size:   0,
@@ -24,9 +39,14 @@ func NewMiddlewareStruct(log logger.LogrusLogger, jwtSess *session.SessionsJWT)
 	}
 
 	return MiddlewareStruct{
-		Logger:   log,
-		respData: &lw,
-		jwtSess:  jwtSess,
+		Logger:         log,
+		respData:       &lw,
+		jwtSess:        jwtSess,
+		adminToken:     adminToken,
+		merchantTokens: merchantTokens,
+		idemStore:      idemStore,
+		idemSecret:     idemSecret,
+		idemTTL:        idemTTL,
 	}
 }
 
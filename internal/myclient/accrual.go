@@ -1,95 +1,193 @@
 package myclient
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/zhenyanesterkova/gmloyalty/internal/config"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/logger"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/money"
 	"github.com/zhenyanesterkova/gmloyalty/internal/service/order"
 )
 
 var (
 	ErrNoContent       = errors.New("order is not registered in payment system")
+	ErrPaused          = errors.New("requests to accrual are paused")
 	ErrTooManyRequests = errors.New("too many requests to payment system")
 	ErrServer          = errors.New("accrual server error")
 )
 
+const (
+	DefaultRetryAfter = 60 * time.Second
+	maxBodyLimitSize  = 1024
+)
+
+var rateLimitRe = regexp.MustCompile(`(\d+)\s+requests\s+per\s+minute`)
+
+// TooManyRequestsError is returned when accrual answers 429.
+// RequestsPerMinute is zero if the response body has no rate hint.
+type TooManyRequestsError struct {
+	RetryAfter        time.Duration
+	RequestsPerMinute int
+}
+
+func (e *TooManyRequestsError) Error() string {
+	return fmt.Sprintf("%v: retry after %v, limit %d requests per minute",
+		ErrTooManyRequests, e.RetryAfter, e.RequestsPerMinute)
+}
+
+func (e *TooManyRequestsError) Unwrap() error {
+	return ErrTooManyRequests
+}
+
+// PausedError is returned while requests to the provider are paused
+// after it answered 429. Until is the end of the pause.
+type PausedError struct {
+	Until time.Time
+}
+
+func (e *PausedError) Error() string {
+	return fmt.Sprintf("%v until %s", ErrPaused, e.Until.Format(time.RFC3339))
+}
+
+func (e *PausedError) Unwrap() error {
+	return ErrPaused
+}
+
+type AccrualClient interface {
+	GetOrderInfo(ctx context.Context, orderNum string) (order.Order, error)
+}
+
 type AccrualStruct struct {
 	client  *http.Client
+	logger  logger.LogrusLogger
 	address string
+	timeout time.Duration
 }
 
 type respStruct struct {
-	Status  string  `json:"status"`
-	Number  string  `json:"order"`
-	Accrual float64 `json:"accrual"`
+	Status  string       `json:"status"`
+	Number  string       `json:"order"`
+	Accrual money.Amount `json:"accrual"`
 }
 
-func Accrual(address string) *AccrualStruct {
-	return &AccrualStruct{
-		address: address,
-		client:  &http.Client{},
+func Accrual(cfg config.CliConfig, log logger.LogrusLogger) (*AccrualStruct, error) {
+	transport, err := newTransport(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed create transport for accrual client: %w", err)
 	}
+
+	return &AccrualStruct{
+		address: cfg.Address,
+		logger:  log,
+		timeout: cfg.RequestTimeout,
+		client: &http.Client{
+			Transport: transport,
+		},
+	}, nil
 }
 
-func (acc AccrualStruct) GetOrderInfo(orderNum string) (order.Order, error) {
+// GetOrderInfo asks accrual about the order. The request is bounded both by
+// ctx and by the configured request timeout.
+func (acc *AccrualStruct) GetOrderInfo(ctx context.Context, orderNum string) (order.Order, error) {
 	url := fmt.Sprintf("%s/api/orders/%s", acc.address, orderNum)
 
-	req, err := http.NewRequest(http.MethodGet, url, http.NoBody)
+	ctx, cancel := context.WithTimeout(ctx, acc.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
 	if err != nil {
 		return order.Order{}, fmt.Errorf("failed create request to accrual - %w", err)
 	}
 
 	resp, err := acc.client.Do(req)
-	defer func(err error) {
-		if err == nil {
-			errBodyClose := resp.Body.Close()
-			if errBodyClose != nil {
-				log.Fatalf("failed close accrual resp body - %v", errBodyClose)
-			}
-		}
-	}(err)
 	if err != nil {
 		return order.Order{}, fmt.Errorf("failed do request - %w", err)
 	}
-
-	if resp.StatusCode == http.StatusTooManyRequests {
-		retryAfter := resp.Header.Get("Retry-After")
-		dur, errParse := time.ParseDuration(retryAfter)
-		if errParse != nil {
-			return order.Order{},
-				fmt.Errorf(`
-					the attempt to re-send req to accrual failed - the retry-after could not be parsed: %w`,
-					errParse,
-				)
-		}
-		time.Sleep(dur)
-		resp, err = acc.client.Do(req)
-		if err != nil {
-			return order.Order{}, fmt.Errorf(`failed send req to accrual: %w, attempts to re-send failed`,
-				err,
-			)
+	defer func() {
+		errBodyClose := resp.Body.Close()
+		if errBodyClose != nil {
+			acc.logger.LogrusLog.Errorf("failed close accrual resp body - %v", errBodyClose)
 		}
-	}
-	if resp.StatusCode == http.StatusNoContent {
+	}()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return order.Order{}, newTooManyRequestsError(resp, time.Now())
+	case resp.StatusCode == http.StatusNoContent:
 		return order.Order{}, ErrNoContent
-	}
-	if resp.StatusCode == http.StatusInternalServerError {
-		return order.Order{}, ErrServer
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return order.Order{}, fmt.Errorf("%w: status code %d", ErrServer, resp.StatusCode)
+	default:
+		return order.Order{}, newInvalidResponseError(ErrUnexpectedStatusCode, "status code %d", resp.StatusCode)
 	}
 
 	orderData := respStruct{}
 	decoder := json.NewDecoder(resp.Body)
 	if err := decoder.Decode(&orderData); err != nil {
-		return order.Order{}, fmt.Errorf("failed decode response from accrual - %w", err)
+		return order.Order{}, newInvalidResponseError(ErrMalformedBody, "%v", err)
 	}
 
-	return order.Order{
+	orderInfo := order.Order{
 		Number:  orderData.Number,
 		Status:  orderData.Status,
 		Accrual: orderData.Accrual,
-	}, nil
+	}
+	if err := ValidateOrder(orderNum, orderInfo); err != nil {
+		return order.Order{}, err
+	}
+
+	return orderInfo, nil
+}
+
+func newTooManyRequestsError(resp *http.Response, now time.Time) *TooManyRequestsError {
+	errTooMany := &TooManyRequestsError{
+		RetryAfter: DefaultRetryAfter,
+	}
+
+	if dur, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now); ok {
+		errTooMany.RetryAfter = dur
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodyLimitSize))
+	if err == nil {
+		if match := rateLimitRe.FindSubmatch(body); match != nil {
+			if rpm, err := strconv.Atoi(string(match[1])); err == nil {
+				errTooMany.RequestsPerMinute = rpm
+			}
+		}
+	}
+
+	return errTooMany
+}
+
+// parseRetryAfter accepts both forms of the Retry-After header:
+// delay in seconds and HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	return max(date.Sub(now), 0), true
 }
@@ -0,0 +1,52 @@
+package myclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/breaker"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/order"
+)
+
+// BreakerClient stops calling accrual while it keeps failing.
+// 204, 429 and invalid answers mean that accrual is alive and are not
+// counted as failures.
+type BreakerClient struct {
+	client  AccrualClient
+	breaker *breaker.Breaker
+}
+
+func WithBreaker(client AccrualClient, br *breaker.Breaker) *BreakerClient {
+	return &BreakerClient{
+		client:  client,
+		breaker: br,
+	}
+}
+
+func (bc *BreakerClient) GetOrderInfo(ctx context.Context, orderNum string) (order.Order, error) {
+	if err := bc.breaker.Allow(); err != nil {
+		return order.Order{}, fmt.Errorf("accrual is unavailable: %w", err)
+	}
+
+	orderData, err := bc.client.GetOrderInfo(ctx, orderNum)
+	if errors.Is(err, context.Canceled) && ctx.Err() != nil {
+		bc.breaker.Cancel()
+		return order.Order{}, fmt.Errorf("failed get order info: %w", err)
+	}
+	if err != nil && !errors.Is(err, ErrNoContent) && !errors.Is(err, ErrTooManyRequests) &&
+		!errors.Is(err, ErrInvalidResponse) {
+		bc.breaker.Failure()
+		return order.Order{}, fmt.Errorf("failed get order info: %w", err)
+	}
+	bc.breaker.Success()
+
+	if err != nil {
+		return order.Order{}, fmt.Errorf("failed get order info: %w", err)
+	}
+	return orderData, nil
+}
+
+func (bc *BreakerClient) State() string {
+	return bc.breaker.State()
+}
@@ -0,0 +1,177 @@
+package fakeaccrual
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/money"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/order"
+)
+
+// Response describes one answer of the fake accrual system.
+// Zero Code means 200 with the order info in the body.
+type Response struct {
+	Status     string
+	Body       string
+	RetryAfter string
+	Accrual    money.Amount
+	Code       int
+	Delay      time.Duration
+}
+
+type respStruct struct {
+	Status  string       `json:"status"`
+	Number  string       `json:"order"`
+	Accrual money.Amount `json:"accrual,omitempty"`
+}
+
+// Server is a scriptable accrual system for tests of the polling pipeline.
+// Every order has its own queue of responses, the last response repeats.
+// Orders without a script get the default response, which is 204.
+type Server struct {
+	*httptest.Server
+	scripts  map[string][]Response
+	requests map[string]int
+	fallback Response
+	mu       sync.Mutex
+}
+
+func New() *Server {
+	srv := &Server{
+		scripts:  map[string][]Response{},
+		requests: map[string]int{},
+		fallback: NoContent(),
+	}
+
+	router := chi.NewRouter()
+	router.Get("/api/orders/{number}", srv.getOrder)
+	srv.Server = httptest.NewServer(router)
+
+	return srv
+}
+
+// Script replaces the responses for the order.
+func (srv *Server) Script(orderNum string, responses ...Response) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.scripts[orderNum] = responses
+}
+
+// SetDefault sets the response for orders without a script.
+func (srv *Server) SetDefault(resp Response) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.fallback = resp
+}
+
+// Requests returns how many times the order was requested.
+func (srv *Server) Requests(orderNum string) int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	return srv.requests[orderNum]
+}
+
+func (srv *Server) next(orderNum string) Response {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.requests[orderNum]++
+
+	script, ok := srv.scripts[orderNum]
+	if !ok || len(script) == 0 {
+		return srv.fallback
+	}
+
+	resp := script[0]
+	if len(script) > 1 {
+		srv.scripts[orderNum] = script[1:]
+	}
+	return resp
+}
+
+func (srv *Server) getOrder(w http.ResponseWriter, r *http.Request) {
+	orderNum := chi.URLParam(r, "number")
+	resp := srv.next(orderNum)
+
+	if resp.Delay > 0 {
+		select {
+		case <-time.After(resp.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if resp.RetryAfter != "" {
+		w.Header().Set("Retry-After", resp.RetryAfter)
+	}
+
+	if resp.Code != 0 && resp.Code != http.StatusOK {
+		w.WriteHeader(resp.Code)
+		if resp.Body != "" {
+			_, _ = w.Write([]byte(resp.Body))
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if resp.Body != "" {
+		_, _ = w.Write([]byte(resp.Body))
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(respStruct{
+		Number:  orderNum,
+		Status:  resp.Status,
+		Accrual: resp.Accrual,
+	})
+}
+
+func Registered() Response {
+	return Response{Status: order.AccrualStatusRegistered}
+}
+
+func Processing() Response {
+	return Response{Status: order.AccrualStatusProcessing}
+}
+
+func Invalid() Response {
+	return Response{Status: order.AccrualStatusInvalid}
+}
+
+func Processed(accrual money.Amount) Response {
+	return Response{Status: order.AccrualStatusProcessed, Accrual: accrual}
+}
+
+func NoContent() Response {
+	return Response{Code: http.StatusNoContent}
+}
+
+func ServerError() Response {
+	return Response{Code: http.StatusInternalServerError}
+}
+
+// TooManyRequests answers 429 with Retry-After in seconds and
+// the rate hint in the body, like the real accrual system does.
+func TooManyRequests(retryAfter time.Duration, requestsPerMinute int) Response {
+	return Response{
+		Code:       http.StatusTooManyRequests,
+		RetryAfter: strconv.Itoa(int(retryAfter.Seconds())),
+		Body:       fmt.Sprintf("No more than %d requests per minute allowed", requestsPerMinute),
+	}
+}
+
+// WithDelay makes the server wait before answering.
+func WithDelay(resp Response, delay time.Duration) Response {
+	resp.Delay = delay
+	return resp
+}
@@ -0,0 +1,161 @@
+package myclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/config"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/breaker"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/logger"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/order"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/ratelimit"
+)
+
+type provider struct {
+	client  *BreakerClient
+	limiter *ratelimit.Limiter
+	pattern *regexp.Regexp
+	name    string
+	prefix  string
+	length  int
+}
+
+func (p provider) match(orderNum string) bool {
+	if p.prefix != "" && !strings.HasPrefix(orderNum, p.prefix) {
+		return false
+	}
+	if p.length > 0 && len(orderNum) != p.length {
+		return false
+	}
+	if p.pattern != nil && !p.pattern.MatchString(orderNum) {
+		return false
+	}
+	return true
+}
+
+// Router sends every order to its accrual provider. Each provider has its
+// own circuit breaker and rate limiter, so an outage or throttling of one
+// backend does not stop the others.
+type Router struct {
+	fallback  provider
+	providers []provider
+}
+
+func NewRouter(cfg config.CliConfig, cfgBreaker config.BreakerConfig, log logger.LogrusLogger) (*Router, error) {
+	newProvider := func(name, address string) (provider, error) {
+		cfgProvider := cfg
+		cfgProvider.Address = address
+
+		client, err := Accrual(cfgProvider, log)
+		if err != nil {
+			return provider{}, fmt.Errorf("failed create client of provider %s: %w", name, err)
+		}
+
+		br := breaker.New(
+			cfgBreaker.FailureThreshold,
+			cfgBreaker.Cooldown,
+			func(from, to string) {
+				log.LogrusLog.Warnf("accrual circuit breaker of provider %s changed state from %s to %s", name, from, to)
+			},
+		)
+
+		return provider{
+			name:    name,
+			client:  WithBreaker(client, br),
+			limiter: ratelimit.New(cfg.RateLimit),
+		}, nil
+	}
+
+	fallback, err := newProvider(config.DefaultProvider, cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	router := &Router{
+		fallback:  fallback,
+		providers: make([]provider, 0, len(cfg.Providers)),
+	}
+	for _, cfgProvider := range cfg.Providers {
+		p, err := newProvider(cfgProvider.Name, cfgProvider.Address)
+		if err != nil {
+			return nil, err
+		}
+
+		p.prefix = cfgProvider.Prefix
+		p.length = cfgProvider.Length
+		if cfgProvider.Pattern != "" {
+			p.pattern, err = regexp.Compile(cfgProvider.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex of provider %s: %w", cfgProvider.Name, err)
+			}
+		}
+		router.providers = append(router.providers, p)
+	}
+
+	return router, nil
+}
+
+func (r *Router) route(orderNum string) provider {
+	for _, p := range r.providers {
+		if p.match(orderNum) {
+			return p
+		}
+	}
+	return r.fallback
+}
+
+// Route returns the name of the provider responsible for the order.
+func (r *Router) Route(orderNum string) string {
+	return r.route(orderNum).name
+}
+
+// GetOrderInfo asks the provider of the order. The returned order
+// has Provider set. While the provider is paused after 429 it returns
+// PausedError at once, so that workers are not blocked by one backend.
+func (r *Router) GetOrderInfo(ctx context.Context, orderNum string) (order.Order, error) {
+	p := r.route(orderNum)
+
+	if until := p.limiter.PausedUntil(); until.After(time.Now()) {
+		return order.Order{}, fmt.Errorf("provider %s: %w", p.name, &PausedError{Until: until})
+	}
+
+	err := p.limiter.Wait(ctx)
+	if err != nil {
+		return order.Order{}, fmt.Errorf("provider %s: %w", p.name, err)
+	}
+
+	orderData, err := p.client.GetOrderInfo(ctx, orderNum)
+	if err != nil {
+		var errTooMany *TooManyRequestsError
+		if errors.As(err, &errTooMany) {
+			p.limiter.Pause(time.Now().Add(errTooMany.RetryAfter))
+			if errTooMany.RequestsPerMinute > 0 {
+				p.limiter.SetRate(errTooMany.RequestsPerMinute)
+			}
+		}
+		return order.Order{}, fmt.Errorf("provider %s: %w", p.name, err)
+	}
+
+	orderData.Provider = p.name
+	return orderData, nil
+}
+
+// State returns the circuit breaker state of the default provider.
+func (r *Router) State() string {
+	return r.fallback.client.State()
+}
+
+// States returns the circuit breaker state of every provider.
+func (r *Router) States() map[string]string {
+	states := map[string]string{
+		r.fallback.name: r.fallback.client.State(),
+	}
+	for _, p := range r.providers {
+		states[p.name] = p.client.State()
+	}
+	return states
+}
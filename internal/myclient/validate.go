@@ -0,0 +1,62 @@
+package myclient
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/order"
+)
+
+var (
+	ErrInvalidResponse      = errors.New("invalid response from accrual")
+	ErrUnknownStatus        = errors.New("unknown order status")
+	ErrOrderMismatch        = errors.New("order number does not match the requested one")
+	ErrNegativeAccrual      = errors.New("accrual is negative")
+	ErrUnexpectedStatusCode = errors.New("unexpected status code")
+	ErrMalformedBody        = errors.New("malformed response body")
+)
+
+// InvalidResponseError means that accrual answered, but the answer can not
+// be trusted. Such orders must not be credited and are quarantined instead.
+type InvalidResponseError struct {
+	Reason error
+	Detail string
+}
+
+func (e *InvalidResponseError) Error() string {
+	return fmt.Sprintf("%v: %v: %s", ErrInvalidResponse, e.Reason, e.Detail)
+}
+
+func (e *InvalidResponseError) Unwrap() []error {
+	return []error{ErrInvalidResponse, e.Reason}
+}
+
+func newInvalidResponseError(reason error, format string, args ...any) *InvalidResponseError {
+	return &InvalidResponseError{
+		Reason: reason,
+		Detail: fmt.Sprintf(format, args...),
+	}
+}
+
+// ValidateOrder checks the order info received from accrual
+// for the requested order number.
+func ValidateOrder(orderNum string, orderData order.Order) error {
+	if orderData.Number != orderNum {
+		return newInvalidResponseError(ErrOrderMismatch, "requested %q, got %q", orderNum, orderData.Number)
+	}
+
+	switch orderData.Status {
+	case order.AccrualStatusRegistered,
+		order.AccrualStatusProcessing,
+		order.AccrualStatusInvalid,
+		order.AccrualStatusProcessed:
+	default:
+		return newInvalidResponseError(ErrUnknownStatus, "status %q", orderData.Status)
+	}
+
+	if orderData.Accrual < 0 {
+		return newInvalidResponseError(ErrNegativeAccrual, "accrual %v", orderData.Accrual)
+	}
+
+	return nil
+}
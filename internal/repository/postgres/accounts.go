@@ -0,0 +1,96 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/ledger"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/order"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/user"
+)
+
+// lockAccaunt returns the accaunt of the user and locks its row until
+// the end of the transaction, so that balance checks and updates of
+// concurrent transactions are serialized.
+func lockAccaunt(ctx context.Context, tx pgx.Tx, userID int) (user.Accaunt, error) {
+	row := tx.QueryRow(
+		ctx,
+		`SELECT id, balance, withdrawn, held FROM accounts
+			WHERE user_id = $1
+			FOR UPDATE;
+		`,
+		userID,
+	)
+
+	acc := user.Accaunt{}
+	acc.UserID = userID
+	err := row.Scan(&acc.ID, &acc.Balance, &acc.Withdrawn, &acc.Held)
+	if err != nil {
+		return user.Accaunt{}, fmt.Errorf("failed lock accaunt of user %d: %w", userID, err)
+	}
+	return acc, nil
+}
+
+// withdraw debits the accaunt locked in tx for the order. It returns
+// ledger.ErrInsufficientFunds if the available balance is less than the sum.
+func withdraw(ctx context.Context, tx pgx.Tx, accaunt user.Accaunt, withdrawInst order.Withdraw) error {
+	if accaunt.Balance-accaunt.Held < withdrawInst.Sum {
+		return fmt.Errorf("failed add withdraw: %w", ledger.ErrInsufficientFunds)
+	}
+
+	_, err := tx.Exec(
+		ctx,
+		`INSERT INTO orders (order_num, user_id, order_status)
+			VALUES ($1, $2, $3);`,
+		withdrawInst.Number,
+		accaunt.UserID,
+		order.StatusNew,
+	)
+	if err != nil {
+		return fmt.Errorf("failed add order to orders in add withdraw transaction: %w", err)
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		`INSERT INTO history (order_num, item_type, sum, merchant)
+		VALUES ($1, $2, $3, NULLIF($4, ''));`,
+		withdrawInst.Number,
+		"withdrawn",
+		withdrawInst.Sum,
+		withdrawInst.Merchant,
+	)
+	if err != nil {
+		return fmt.Errorf("failed exec query add history item in add withdraw transaction: %w", err)
+	}
+
+	err = insertPosting(ctx, tx, ledger.Withdrawal(accaunt.ID, withdrawInst.Number, withdrawInst.Sum))
+	if err != nil {
+		return fmt.Errorf("failed add withdraw: %w", err)
+	}
+
+	err = consumeLots(ctx, tx, accaunt.ID, withdrawInst.Number, withdrawInst.Sum)
+	if err != nil {
+		return fmt.Errorf("failed add withdraw: %w", err)
+	}
+
+	tag, err := tx.Exec(
+		ctx,
+		`UPDATE accounts SET
+			balance = balance - $1,
+			withdrawn = withdrawn + $1
+		WHERE 
+			id = $2 AND balance - held >= $1;`,
+		withdrawInst.Sum,
+		accaunt.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed exec query update user accaunt in add withdraw transaction: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("failed add withdraw: %w", ledger.ErrInsufficientFunds)
+	}
+
+	return nil
+}
@@ -0,0 +1,363 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/order"
+)
+
+func (psg *PostgresStorage) ClaimAccrualJobs(
+	ctx context.Context,
+	owner string,
+	lease time.Duration,
+	limit int,
+) ([]order.AccrualJob, error) {
+	rows, err := psg.pool.Query(
+		ctx,
+		`WITH claimed AS (
+			UPDATE accrual_jobs SET
+				locked_by = $1,
+				locked_until = NOW() + $2 * INTERVAL '1 millisecond'
+			WHERE order_num IN (
+				SELECT order_num FROM accrual_jobs
+				WHERE next_attempt_at <= NOW()
+					AND dead_at IS NULL
+					AND quarantined_at IS NULL
+					AND (locked_until IS NULL OR locked_until < NOW())
+				ORDER BY next_attempt_at
+				LIMIT $3
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING order_num, created_at, next_attempt_at, attempts, last_error
+		)
+		SELECT
+			orders.order_num,
+			orders.order_status,
+			orders.upload_time,
+			orders.user_id,
+			claimed.created_at,
+			claimed.next_attempt_at,
+			claimed.attempts,
+			claimed.last_error
+		FROM orders
+		INNER JOIN claimed
+		ON orders.order_num = claimed.order_num;
+		`,
+		owner,
+		lease.Milliseconds(),
+		limit,
+	)
+	if err != nil {
+		return []order.AccrualJob{}, fmt.Errorf("failed query claim accrual jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := []order.AccrualJob{}
+	for rows.Next() {
+		job := order.AccrualJob{}
+		err := rows.Scan(
+			&job.Order.Number,
+			&job.Order.Status,
+			&job.Order.UploadTime,
+			&job.Order.UserID,
+			&job.CreatedAt,
+			&job.NextAttemptAt,
+			&job.Attempts,
+			&job.LastError,
+		)
+		if err != nil {
+			return []order.AccrualJob{}, fmt.Errorf("failed scan rows when claim accrual jobs: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return []order.AccrualJob{}, fmt.Errorf("failed read rows when claim accrual jobs: %w", err)
+	}
+
+	return jobs, nil
+}
+
+// RenewAccrualJobLease extends the lease on the job held by owner.
+// It returns false if the lease has been taken over by another owner.
+func (psg *PostgresStorage) RenewAccrualJobLease(
+	ctx context.Context,
+	owner string,
+	orderNum string,
+	lease time.Duration,
+) (bool, error) {
+	tag, err := psg.pool.Exec(
+		ctx,
+		`UPDATE accrual_jobs SET
+			locked_until = NOW() + $1 * INTERVAL '1 millisecond'
+		WHERE
+			order_num = $2 AND locked_by = $3;`,
+		lease.Milliseconds(),
+		orderNum,
+		owner,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed renew accrual job lease: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (psg *PostgresStorage) ReleaseAccrualJob(ctx context.Context, owner string, job order.AccrualJob) error {
+	_, err := psg.pool.Exec(
+		ctx,
+		`UPDATE accrual_jobs SET
+			locked_by = NULL,
+			locked_until = NULL,
+			attempts = $1,
+			next_attempt_at = $2,
+			last_error = $3
+		WHERE
+			order_num = $4 AND locked_by = $5;`,
+		job.Attempts,
+		job.NextAttemptAt,
+		job.LastError,
+		job.Order.Number,
+		owner,
+	)
+	if err != nil {
+		return fmt.Errorf("failed release accrual job: %w", err)
+	}
+	return nil
+}
+
+func (psg *PostgresStorage) ResetAccrualJobs(ctx context.Context, owner string) error {
+	_, err := psg.pool.Exec(
+		ctx,
+		`UPDATE accrual_jobs SET
+			locked_by = NULL,
+			locked_until = NULL
+		WHERE
+			locked_by = $1;`,
+		owner,
+	)
+	if err != nil {
+		return fmt.Errorf("failed reset accrual jobs: %w", err)
+	}
+	return nil
+}
+
+func (psg *PostgresStorage) DeadLetterAccrualJob(ctx context.Context, owner string, job order.AccrualJob) error {
+	_, err := psg.pool.Exec(
+		ctx,
+		`UPDATE accrual_jobs SET
+			locked_by = NULL,
+			locked_until = NULL,
+			attempts = $1,
+			last_error = $2,
+			dead_at = NOW()
+		WHERE
+			order_num = $3 AND locked_by = $4;`,
+		job.Attempts,
+		job.LastError,
+		job.Order.Number,
+		owner,
+	)
+	if err != nil {
+		return fmt.Errorf("failed move accrual job to dead letters: %w", err)
+	}
+	return nil
+}
+
+func (psg *PostgresStorage) GetAccrualJob(ctx context.Context, orderNum string) (order.AccrualJob, error) {
+	row := psg.pool.QueryRow(
+		ctx,
+		`SELECT
+			orders.order_num,
+			orders.order_status,
+			orders.upload_time,
+			orders.user_id,
+			accrual_jobs.created_at,
+			accrual_jobs.next_attempt_at,
+			accrual_jobs.attempts,
+			accrual_jobs.last_error,
+			accrual_jobs.dead_at,
+			accrual_jobs.quarantined_at
+		FROM accrual_jobs
+		INNER JOIN orders
+		ON orders.order_num = accrual_jobs.order_num
+		WHERE accrual_jobs.order_num = $1;
+		`,
+		orderNum,
+	)
+
+	job := order.AccrualJob{}
+	err := row.Scan(
+		&job.Order.Number,
+		&job.Order.Status,
+		&job.Order.UploadTime,
+		&job.Order.UserID,
+		&job.CreatedAt,
+		&job.NextAttemptAt,
+		&job.Attempts,
+		&job.LastError,
+		&job.DeadAt,
+		&job.QuarantinedAt,
+	)
+	if err != nil {
+		return order.AccrualJob{}, fmt.Errorf("failed to scan row when get accrual job: %w", err)
+	}
+
+	return job, nil
+}
+
+func (psg *PostgresStorage) DeadAccrualJobs(ctx context.Context) ([]order.AccrualJob, error) {
+	rows, err := psg.pool.Query(
+		ctx,
+		`SELECT
+			orders.order_num,
+			orders.order_status,
+			orders.upload_time,
+			orders.user_id,
+			accrual_jobs.created_at,
+			accrual_jobs.next_attempt_at,
+			accrual_jobs.attempts,
+			accrual_jobs.last_error,
+			accrual_jobs.dead_at,
+			accrual_jobs.quarantined_at
+		FROM accrual_jobs
+		INNER JOIN orders
+		ON orders.order_num = accrual_jobs.order_num
+		WHERE accrual_jobs.dead_at IS NOT NULL
+		ORDER BY accrual_jobs.dead_at DESC;
+		`,
+	)
+	if err != nil {
+		return []order.AccrualJob{}, fmt.Errorf("failed query get dead accrual jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := []order.AccrualJob{}
+	for rows.Next() {
+		job := order.AccrualJob{}
+		err := rows.Scan(
+			&job.Order.Number,
+			&job.Order.Status,
+			&job.Order.UploadTime,
+			&job.Order.UserID,
+			&job.CreatedAt,
+			&job.NextAttemptAt,
+			&job.Attempts,
+			&job.LastError,
+			&job.DeadAt,
+			&job.QuarantinedAt,
+		)
+		if err != nil {
+			return []order.AccrualJob{}, fmt.Errorf("failed scan rows when get dead accrual jobs: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return []order.AccrualJob{}, fmt.Errorf("failed read rows when get dead accrual jobs: %w", err)
+	}
+
+	return jobs, nil
+}
+
+// RequeueAccrualJob returns a dead-lettered or quarantined job to processing.
+// It returns pgx.ErrNoRows if there is no such job for the order.
+func (psg *PostgresStorage) RequeueAccrualJob(ctx context.Context, orderNum string) error {
+	tag, err := psg.pool.Exec(
+		ctx,
+		`UPDATE accrual_jobs SET
+			attempts = 0,
+			next_attempt_at = NOW(),
+			dead_at = NULL,
+			quarantined_at = NULL
+		WHERE
+			order_num = $1 AND (dead_at IS NOT NULL OR quarantined_at IS NOT NULL);`,
+		orderNum,
+	)
+	if err != nil {
+		return fmt.Errorf("failed requeue accrual job: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("failed requeue accrual job: %w", pgx.ErrNoRows)
+	}
+	return nil
+}
+
+// QuarantineAccrualJob parks the job after an invalid answer from accrual.
+// It does not check the lease owner, because invalid answers also come from
+// callbacks. It returns pgx.ErrNoRows if the order has no job.
+func (psg *PostgresStorage) QuarantineAccrualJob(ctx context.Context, orderNum, reason string) error {
+	tag, err := psg.pool.Exec(
+		ctx,
+		`UPDATE accrual_jobs SET
+			locked_by = NULL,
+			locked_until = NULL,
+			last_error = $1,
+			quarantined_at = NOW()
+		WHERE
+			order_num = $2;`,
+		reason,
+		orderNum,
+	)
+	if err != nil {
+		return fmt.Errorf("failed quarantine accrual job: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("failed quarantine accrual job: %w", pgx.ErrNoRows)
+	}
+	return nil
+}
+
+func (psg *PostgresStorage) QuarantinedAccrualJobs(ctx context.Context) ([]order.AccrualJob, error) {
+	rows, err := psg.pool.Query(
+		ctx,
+		`SELECT
+			orders.order_num,
+			orders.order_status,
+			orders.upload_time,
+			orders.user_id,
+			accrual_jobs.created_at,
+			accrual_jobs.next_attempt_at,
+			accrual_jobs.attempts,
+			accrual_jobs.last_error,
+			accrual_jobs.dead_at,
+			accrual_jobs.quarantined_at
+		FROM accrual_jobs
+		INNER JOIN orders
+		ON orders.order_num = accrual_jobs.order_num
+		WHERE accrual_jobs.quarantined_at IS NOT NULL
+		ORDER BY accrual_jobs.quarantined_at DESC;
+		`,
+	)
+	if err != nil {
+		return []order.AccrualJob{}, fmt.Errorf("failed query get quarantined accrual jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := []order.AccrualJob{}
+	for rows.Next() {
+		job := order.AccrualJob{}
+		err := rows.Scan(
+			&job.Order.Number,
+			&job.Order.Status,
+			&job.Order.UploadTime,
+			&job.Order.UserID,
+			&job.CreatedAt,
+			&job.NextAttemptAt,
+			&job.Attempts,
+			&job.LastError,
+			&job.DeadAt,
+			&job.QuarantinedAt,
+		)
+		if err != nil {
+			return []order.AccrualJob{}, fmt.Errorf("failed scan rows when get quarantined accrual jobs: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return []order.AccrualJob{}, fmt.Errorf("failed read rows when get quarantined accrual jobs: %w", err)
+	}
+
+	return jobs, nil
+}
@@ -0,0 +1,247 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/ledger"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/order"
+)
+
+// HoldPoints reserves the sum of the hold on the user balance for timeout.
+// It returns order.ErrOrderExists if the order number is already used
+// and ledger.ErrInsufficientFunds if the available balance is less than the sum.
+func (psg *PostgresStorage) HoldPoints(
+	ctx context.Context,
+	userID int,
+	hold order.Hold,
+	timeout time.Duration,
+) (order.Hold, error) {
+	log := psg.log.LogrusLog
+
+	tx, err := psg.pool.Begin(ctx)
+	if err != nil {
+		return order.Hold{}, fmt.Errorf("failed start hold points transaction: %w", err)
+	}
+
+	defer func() {
+		errRollback := tx.Rollback(ctx)
+		if errRollback != nil {
+			if !errors.Is(errRollback, pgx.ErrTxClosed) {
+				log.Errorf("failed rolls back hold points transaction: %v", errRollback)
+			}
+		}
+	}()
+
+	accaunt, err := lockAccaunt(ctx, tx, userID)
+	if err != nil {
+		return order.Hold{}, fmt.Errorf("failed get accaunt in hold points transaction: %w", err)
+	}
+
+	var used bool
+	err = tx.QueryRow(
+		ctx,
+		`SELECT
+			EXISTS(SELECT 1 FROM orders WHERE order_num = $1)
+			OR EXISTS(SELECT 1 FROM holds WHERE order_num = $1);`,
+		hold.Number,
+	).Scan(&used)
+	if err != nil {
+		return order.Hold{}, fmt.Errorf("failed check order number in hold points transaction: %w", err)
+	}
+	if used {
+		return order.Hold{}, fmt.Errorf("failed hold points: %w", order.ErrOrderExists)
+	}
+
+	if accaunt.Balance-accaunt.Held < hold.Sum {
+		return order.Hold{}, fmt.Errorf("failed hold points: %w", ledger.ErrInsufficientFunds)
+	}
+
+	hold.UserID = userID
+	hold.Status = order.HoldStatusHeld
+	err = tx.QueryRow(
+		ctx,
+		`INSERT INTO holds (order_num, user_id, sum, status, expires_at, merchant)
+			VALUES ($1, $2, $3, $4, NOW() + $5 * INTERVAL '1 millisecond', NULLIF($6, ''))
+		RETURNING created_at, expires_at;`,
+		hold.Number,
+		userID,
+		hold.Sum,
+		hold.Status,
+		timeout.Milliseconds(),
+		hold.Merchant,
+	).Scan(&hold.CreatedAt, &hold.ExpiresAt)
+	if err != nil {
+		return order.Hold{}, fmt.Errorf("failed insert hold in hold points transaction: %w", err)
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		`UPDATE accounts SET
+			held = held + $1
+		WHERE
+			id = $2;`,
+		hold.Sum,
+		accaunt.ID,
+	)
+	if err != nil {
+		return order.Hold{}, fmt.Errorf("failed update accaunt in hold points transaction: %w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return order.Hold{}, fmt.Errorf("failed commits the transaction hold points: %w", err)
+	}
+	return hold, nil
+}
+
+// CaptureHold turns the hold of the user into a withdrawal for the order.
+// An expired hold is released instead and order.ErrHoldExpired is returned.
+func (psg *PostgresStorage) CaptureHold(ctx context.Context, userID int, orderNum string) (order.Hold, error) {
+	return psg.finishHold(ctx, userID, orderNum, order.HoldStatusCaptured)
+}
+
+// ReleaseHold returns the sum of the hold of the user to the available balance.
+func (psg *PostgresStorage) ReleaseHold(ctx context.Context, userID int, orderNum string) (order.Hold, error) {
+	return psg.finishHold(ctx, userID, orderNum, order.HoldStatusReleased)
+}
+
+// ReleaseExpiredHolds releases the holds that are neither captured nor
+// released in time and returns the number of holds released.
+func (psg *PostgresStorage) ReleaseExpiredHolds(ctx context.Context) (int, error) {
+	rows, err := psg.pool.Query(
+		ctx,
+		`SELECT user_id, order_num FROM holds
+			WHERE status = $1 AND expires_at <= NOW();
+		`,
+		order.HoldStatusHeld,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed query expired holds: %w", err)
+	}
+
+	holds, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (order.Hold, error) {
+		hold := order.Hold{}
+		err := row.Scan(&hold.UserID, &hold.Number)
+		return hold, err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed scan expired holds: %w", err)
+	}
+
+	released := 0
+	for _, hold := range holds {
+		_, err := psg.finishHold(ctx, hold.UserID, hold.Number, order.HoldStatusReleased)
+		if err != nil {
+			// captured or released by the user in between
+			if errors.Is(err, order.ErrHoldNotActive) {
+				continue
+			}
+			return released, err
+		}
+		released++
+	}
+	return released, nil
+}
+
+func (psg *PostgresStorage) finishHold(
+	ctx context.Context,
+	userID int,
+	orderNum string,
+	status string,
+) (order.Hold, error) {
+	log := psg.log.LogrusLog
+
+	tx, err := psg.pool.Begin(ctx)
+	if err != nil {
+		return order.Hold{}, fmt.Errorf("failed start finish hold transaction: %w", err)
+	}
+
+	defer func() {
+		errRollback := tx.Rollback(ctx)
+		if errRollback != nil {
+			if !errors.Is(errRollback, pgx.ErrTxClosed) {
+				log.Errorf("failed rolls back finish hold transaction: %v", errRollback)
+			}
+		}
+	}()
+
+	accaunt, err := lockAccaunt(ctx, tx, userID)
+	if err != nil {
+		return order.Hold{}, fmt.Errorf("failed get accaunt in finish hold transaction: %w", err)
+	}
+
+	hold := order.Hold{Number: orderNum, UserID: userID}
+	var expired bool
+	err = tx.QueryRow(
+		ctx,
+		`SELECT sum, status, created_at, expires_at, expires_at <= NOW(), COALESCE(merchant, '')
+			FROM holds
+			WHERE order_num = $1 AND user_id = $2;
+		`,
+		orderNum,
+		userID,
+	).Scan(&hold.Sum, &hold.Status, &hold.CreatedAt, &hold.ExpiresAt, &expired, &hold.Merchant)
+	if err != nil {
+		return order.Hold{}, fmt.Errorf("failed get hold in finish hold transaction: %w", err)
+	}
+
+	if hold.Status != order.HoldStatusHeld {
+		return order.Hold{}, fmt.Errorf("failed finish hold %s: %w", orderNum, order.ErrHoldNotActive)
+	}
+
+	var errExpired error
+	if expired && status == order.HoldStatusCaptured {
+		status = order.HoldStatusReleased
+		errExpired = fmt.Errorf("failed capture hold %s: %w", orderNum, order.ErrHoldExpired)
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		`UPDATE holds SET
+			status = $1,
+			finished_at = NOW()
+		WHERE
+			order_num = $2;`,
+		status,
+		orderNum,
+	)
+	if err != nil {
+		return order.Hold{}, fmt.Errorf("failed update hold in finish hold transaction: %w", err)
+	}
+	hold.Status = status
+
+	_, err = tx.Exec(
+		ctx,
+		`UPDATE accounts SET
+			held = held - $1
+		WHERE
+			id = $2;`,
+		hold.Sum,
+		accaunt.ID,
+	)
+	if err != nil {
+		return order.Hold{}, fmt.Errorf("failed update accaunt in finish hold transaction: %w", err)
+	}
+	accaunt.Held -= hold.Sum
+
+	if status == order.HoldStatusCaptured {
+		err = withdraw(ctx, tx, accaunt, order.Withdraw{Number: orderNum, Sum: hold.Sum, Merchant: hold.Merchant})
+		if err != nil {
+			return order.Hold{}, fmt.Errorf("failed capture hold: %w", err)
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return order.Hold{}, fmt.Errorf("failed commits the transaction finish hold: %w", err)
+	}
+	if errExpired != nil {
+		return order.Hold{}, errExpired
+	}
+	return hold, nil
+}
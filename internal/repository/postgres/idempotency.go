@@ -0,0 +1,166 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/idempotency"
+)
+
+const reserveAttempts = 2
+
+// ReserveIdempotencyKey stores the key for the request being handled.
+// It returns true if the key is new or its previous record has expired,
+// otherwise it returns the stored record.
+func (psg *PostgresStorage) ReserveIdempotencyKey(
+	ctx context.Context,
+	key idempotency.Key,
+	ttl time.Duration,
+) (idempotency.Record, bool, error) {
+	for range reserveAttempts {
+		tag, err := psg.pool.Exec(
+			ctx,
+			`INSERT INTO idempotency_keys (user_id, scope, path, idem_key, request_hash, expires_at)
+				VALUES ($1, $2, $3, $4, $5, NOW() + $6 * INTERVAL '1 millisecond')
+			ON CONFLICT (user_id, scope, path, idem_key) DO UPDATE SET
+				request_hash = EXCLUDED.request_hash,
+				status_code = NULL,
+				header = NULL,
+				body = NULL,
+				subject_user_id = NULL,
+				created_at = NOW(),
+				expires_at = EXCLUDED.expires_at
+			WHERE idempotency_keys.expires_at < NOW();`,
+			key.UserID,
+			key.Scope,
+			key.Path,
+			key.Value,
+			key.RequestHash,
+			ttl.Milliseconds(),
+		)
+		if err != nil {
+			return idempotency.Record{}, false, fmt.Errorf("failed reserve idempotency key: %w", err)
+		}
+		if tag.RowsAffected() > 0 {
+			return idempotency.Record{}, true, nil
+		}
+
+		record, err := psg.getIdempotencyRecord(ctx, key)
+		if err != nil {
+			// the first request failed and released the key in between
+			if errors.Is(err, pgx.ErrNoRows) {
+				continue
+			}
+			return idempotency.Record{}, false, err
+		}
+		return record, false, nil
+	}
+
+	return idempotency.Record{}, false, fmt.Errorf("failed reserve idempotency key %s", key.Value)
+}
+
+func (psg *PostgresStorage) getIdempotencyRecord(ctx context.Context, key idempotency.Key) (idempotency.Record, error) {
+	row := psg.pool.QueryRow(
+		ctx,
+		`SELECT request_hash, status_code, COALESCE(header, '{}'::JSONB), COALESCE(body, ''::BYTEA),
+				COALESCE(subject_user_id, 0)
+			FROM idempotency_keys
+			WHERE user_id = $1 AND scope = $2 AND path = $3 AND idem_key = $4;
+		`,
+		key.UserID,
+		key.Scope,
+		key.Path,
+		key.Value,
+	)
+
+	var (
+		record     idempotency.Record
+		statusCode *int
+		header     http.Header
+		body       []byte
+		subject    int
+	)
+	err := row.Scan(&record.RequestHash, &statusCode, &header, &body, &subject)
+	if err != nil {
+		return idempotency.Record{}, fmt.Errorf("failed to scan row when get idempotency key: %w", err)
+	}
+
+	if statusCode != nil {
+		record.Response = &idempotency.Response{
+			StatusCode: *statusCode,
+			Header:     header,
+			Body:       body,
+			Subject:    subject,
+		}
+	}
+	return record, nil
+}
+
+func (psg *PostgresStorage) SaveIdempotentResponse(
+	ctx context.Context,
+	key idempotency.Key,
+	resp idempotency.Response,
+) error {
+	_, err := psg.pool.Exec(
+		ctx,
+		`UPDATE idempotency_keys SET
+			status_code = $1,
+			header = $2,
+			body = $3,
+			subject_user_id = NULLIF($4, 0)
+		WHERE
+			user_id = $5 AND scope = $6 AND path = $7 AND idem_key = $8 AND request_hash = $9;`,
+		resp.StatusCode,
+		resp.Header,
+		resp.Body,
+		resp.Subject,
+		key.UserID,
+		key.Scope,
+		key.Path,
+		key.Value,
+		key.RequestHash,
+	)
+	if err != nil {
+		return fmt.Errorf("failed save idempotent response: %w", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey removes the reservation of a request that has not
+// got a response worth replaying, so that a retry is handled again.
+func (psg *PostgresStorage) ReleaseIdempotencyKey(ctx context.Context, key idempotency.Key) error {
+	_, err := psg.pool.Exec(
+		ctx,
+		`DELETE FROM idempotency_keys
+		WHERE
+			user_id = $1 AND scope = $2 AND path = $3 AND idem_key = $4
+			AND request_hash = $5 AND status_code IS NULL;`,
+		key.UserID,
+		key.Scope,
+		key.Path,
+		key.Value,
+		key.RequestHash,
+	)
+	if err != nil {
+		return fmt.Errorf("failed release idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (psg *PostgresStorage) PurgeIdempotencyKeys(ctx context.Context) (int64, error) {
+	tag, err := psg.pool.Exec(
+		ctx,
+		`DELETE FROM idempotency_keys
+		WHERE
+			expires_at < NOW();`,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed purge expired idempotency keys: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,168 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/ledger"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/money"
+)
+
+// insertPosting appends the posting to the ledger. Zero postings
+// move nothing and are not recorded.
+func insertPosting(ctx context.Context, tx pgx.Tx, posting ledger.Posting) error {
+	if posting.Amount == 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(
+		ctx,
+		`INSERT INTO ledger (kind, debit_account, credit_account, amount, order_num, comment)
+			VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6);`,
+		posting.Kind,
+		posting.Debit,
+		posting.Credit,
+		posting.Amount,
+		posting.OrderNum,
+		posting.Comment,
+	)
+	if err != nil {
+		return fmt.Errorf("failed insert %s posting to ledger: %w", posting.Kind, err)
+	}
+	return nil
+}
+
+// AdjustBalance corrects the balance of the user by a manual posting.
+// It returns ledger.ErrInsufficientFunds if the balance would become negative.
+func (psg *PostgresStorage) AdjustBalance(ctx context.Context, userID int, amount money.Amount, comment string) error {
+	log := psg.log.LogrusLog
+
+	tx, err := psg.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed start adjust balance transaction: %w", err)
+	}
+
+	defer func() {
+		errRollback := tx.Rollback(ctx)
+		if errRollback != nil {
+			if !errors.Is(errRollback, pgx.ErrTxClosed) {
+				log.Errorf("failed rolls back adjust balance transaction: %v", errRollback)
+			}
+		}
+	}()
+
+	accaunt, err := lockAccaunt(ctx, tx, userID)
+	if err != nil {
+		return fmt.Errorf("failed adjust balance: %w", err)
+	}
+
+	if accaunt.Balance-accaunt.Held+amount < 0 {
+		return fmt.Errorf("failed adjust balance: %w", ledger.ErrInsufficientFunds)
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		`UPDATE accounts SET
+			balance = balance + $1
+		WHERE
+			id = $2;`,
+		amount,
+		accaunt.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed update accaunt in adjust balance transaction: %w", err)
+	}
+
+	err = insertPosting(ctx, tx, ledger.Adjustment(accaunt.ID, amount, comment))
+	if err != nil {
+		return fmt.Errorf("failed adjust balance: %w", err)
+	}
+
+	if amount > 0 {
+		err = psg.addLot(ctx, tx, accaunt.ID, "", amount)
+	} else {
+		err = consumeLots(ctx, tx, accaunt.ID, "", -amount)
+	}
+	if err != nil {
+		return fmt.Errorf("failed adjust balance: %w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed commits the transaction adjust balance: %w", err)
+	}
+	return nil
+}
+
+// Reconcile compares balance and withdrawn of every account with
+// the totals derived from the ledger and returns the accounts that differ.
+func (psg *PostgresStorage) Reconcile(ctx context.Context) ([]ledger.Discrepancy, error) {
+	rows, err := psg.pool.Query(
+		ctx,
+		`WITH movements AS (
+			SELECT
+				credit_account AS account,
+				amount AS balance,
+				CASE WHEN kind = $2 THEN -amount ELSE 0 END AS withdrawn
+			FROM ledger
+			UNION ALL
+			SELECT
+				debit_account AS account,
+				-amount AS balance,
+				CASE WHEN kind = $1 THEN amount ELSE 0 END AS withdrawn
+			FROM ledger
+		), totals AS (
+			SELECT
+				account,
+				SUM(balance) AS balance,
+				SUM(withdrawn) AS withdrawn
+			FROM movements
+			GROUP BY account
+		)
+		SELECT
+			accounts.id,
+			accounts.user_id,
+			accounts.balance,
+			accounts.withdrawn,
+			COALESCE(totals.balance, 0),
+			COALESCE(totals.withdrawn, 0)
+		FROM accounts
+		LEFT JOIN totals
+		ON totals.account = 'user:' || accounts.id
+		WHERE accounts.balance <> COALESCE(totals.balance, 0)
+			OR accounts.withdrawn <> COALESCE(totals.withdrawn, 0)
+		ORDER BY accounts.id;
+		`,
+		ledger.KindWithdrawal,
+		ledger.KindRefund,
+	)
+	if err != nil {
+		return []ledger.Discrepancy{}, fmt.Errorf("failed query reconcile accounts: %w", err)
+	}
+	defer rows.Close()
+
+	discrepancies := []ledger.Discrepancy{}
+	for rows.Next() {
+		d := ledger.Discrepancy{}
+		err := rows.Scan(
+			&d.AccountID,
+			&d.UserID,
+			&d.CachedBalance,
+			&d.CachedWithdrawn,
+			&d.LedgerBalance,
+			&d.LedgerWithdrawn,
+		)
+		if err != nil {
+			return []ledger.Discrepancy{}, fmt.Errorf("failed scan rows when reconcile accounts: %w", err)
+		}
+		discrepancies = append(discrepancies, d)
+	}
+	if err := rows.Err(); err != nil {
+		return []ledger.Discrepancy{}, fmt.Errorf("failed read rows when reconcile accounts: %w", err)
+	}
+
+	return discrepancies, nil
+}
@@ -0,0 +1,359 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/ledger"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/money"
+)
+
+// pointLot is a part of the balance credited at once. A lot expires
+// psg.expiry.Months after it is credited, debits consume the oldest
+// lots first. The sum of remaining of all lots equals the balance.
+// Lots are changed only under the lock of their accaunt.
+type pointLot struct {
+	orderNum  *string
+	id        int64
+	remaining money.Amount
+}
+
+// addLot credits the accaunt with a new lot of points.
+func (psg *PostgresStorage) addLot(
+	ctx context.Context,
+	tx pgx.Tx,
+	accountID int,
+	orderNum string,
+	amount money.Amount,
+) error {
+	if amount <= 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(
+		ctx,
+		`INSERT INTO point_lots (account_id, order_num, amount, remaining, expires_at)
+			VALUES (
+				$1,
+				NULLIF($2, ''),
+				$3,
+				$3,
+				CASE WHEN $4::INT > 0 THEN NOW() + make_interval(months => $4::INT) END
+			);`,
+		accountID,
+		orderNum,
+		amount,
+		psg.expiry.Months,
+	)
+	if err != nil {
+		return fmt.Errorf("failed insert lot of points: %w", err)
+	}
+	return nil
+}
+
+// consumeLots debits the accaunt by amount taking points from the oldest lots.
+// Points taken for an order are recorded, so that a refund of the order
+// can put them back into the same lots.
+func consumeLots(ctx context.Context, tx pgx.Tx, accountID int, orderNum string, amount money.Amount) error {
+	if amount <= 0 {
+		return nil
+	}
+
+	rows, err := tx.Query(
+		ctx,
+		`SELECT id, remaining FROM point_lots
+			WHERE account_id = $1 AND remaining > 0
+			ORDER BY credited_at, id;
+		`,
+		accountID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed query lots of points: %w", err)
+	}
+
+	lots, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (pointLot, error) {
+		lot := pointLot{}
+		err := row.Scan(&lot.id, &lot.remaining)
+		return lot, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed scan lots of points: %w", err)
+	}
+
+	for _, lot := range lots {
+		if amount == 0 {
+			break
+		}
+		take := min(lot.remaining, amount)
+		_, err = tx.Exec(
+			ctx,
+			`UPDATE point_lots SET
+				remaining = remaining - $1
+			WHERE
+				id = $2;`,
+			take,
+			lot.id,
+		)
+		if err != nil {
+			return fmt.Errorf("failed update lot of points: %w", err)
+		}
+
+		if orderNum != "" {
+			_, err = tx.Exec(
+				ctx,
+				`INSERT INTO lot_consumptions (lot_id, order_num, amount)
+					VALUES ($1, $2, $3);`,
+				lot.id,
+				orderNum,
+				take,
+			)
+			if err != nil {
+				return fmt.Errorf("failed insert consumption of lot of points: %w", err)
+			}
+		}
+		amount -= take
+	}
+
+	if amount > 0 {
+		return fmt.Errorf("failed consume lots of points: %w", ledger.ErrInsufficientFunds)
+	}
+	return nil
+}
+
+// restoreLots puts amount back into the lots consumed by the order, the lots
+// that expire last first, so that refunded points keep their original
+// expiry. It returns the part of amount that was not consumed from lots
+// on record, e.g. by withdrawals made before consumptions were recorded.
+func restoreLots(ctx context.Context, tx pgx.Tx, orderNum string, amount money.Amount) (money.Amount, error) {
+	if amount <= 0 {
+		return 0, nil
+	}
+
+	rows, err := tx.Query(
+		ctx,
+		`SELECT c.lot_id, c.amount - c.restored
+			FROM lot_consumptions c
+			INNER JOIN point_lots l
+			ON l.id = c.lot_id
+			WHERE c.order_num = $1 AND c.amount > c.restored
+			ORDER BY l.expires_at DESC NULLS FIRST, l.id DESC;
+		`,
+		orderNum,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed query consumed lots of points: %w", err)
+	}
+
+	lots, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (pointLot, error) {
+		lot := pointLot{}
+		err := row.Scan(&lot.id, &lot.remaining)
+		return lot, err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed scan consumed lots of points: %w", err)
+	}
+
+	for _, lot := range lots {
+		if amount == 0 {
+			break
+		}
+		take := min(lot.remaining, amount)
+		// an expired lot gets the points back and expires them again
+		_, err = tx.Exec(
+			ctx,
+			`UPDATE point_lots SET
+				remaining = remaining + $1,
+				expired_at = NULL
+			WHERE
+				id = $2;`,
+			take,
+			lot.id,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed update restored lot of points: %w", err)
+		}
+
+		_, err = tx.Exec(
+			ctx,
+			`UPDATE lot_consumptions SET
+				restored = restored + $1
+			WHERE
+				lot_id = $2 AND order_num = $3;`,
+			take,
+			lot.id,
+			orderNum,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed update consumption of lot of points: %w", err)
+		}
+		amount -= take
+	}
+	return amount, nil
+}
+
+// ExpirePoints writes off the points of all expired lots and returns
+// the number of lots expired.
+func (psg *PostgresStorage) ExpirePoints(ctx context.Context) (int, error) {
+	rows, err := psg.pool.Query(
+		ctx,
+		`SELECT DISTINCT accounts.user_id
+			FROM point_lots
+			INNER JOIN accounts
+			ON accounts.id = point_lots.account_id
+			WHERE point_lots.remaining > 0 AND point_lots.expires_at <= NOW()
+				AND accounts.balance > accounts.held;
+		`,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed query accounts with expired points: %w", err)
+	}
+
+	userIDs, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return 0, fmt.Errorf("failed scan accounts with expired points: %w", err)
+	}
+
+	expired := 0
+	for _, userID := range userIDs {
+		n, err := psg.expireAccauntPoints(ctx, userID)
+		if err != nil {
+			return expired, err
+		}
+		expired += n
+	}
+	return expired, nil
+}
+
+// expireAccauntPoints writes off expired lots of the accaunt, but never
+// the points reserved by active holds: they stay in the lots and expire
+// once the hold is released.
+func (psg *PostgresStorage) expireAccauntPoints(ctx context.Context, userID int) (int, error) {
+	log := psg.log.LogrusLog
+
+	tx, err := psg.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed start expire points transaction: %w", err)
+	}
+
+	defer func() {
+		errRollback := tx.Rollback(ctx)
+		if errRollback != nil {
+			if !errors.Is(errRollback, pgx.ErrTxClosed) {
+				log.Errorf("failed rolls back expire points transaction: %v", errRollback)
+			}
+		}
+	}()
+
+	accaunt, err := lockAccaunt(ctx, tx, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed get accaunt in expire points transaction: %w", err)
+	}
+
+	rows, err := tx.Query(
+		ctx,
+		`SELECT id, remaining, order_num FROM point_lots
+			WHERE account_id = $1 AND remaining > 0 AND expires_at <= NOW()
+			ORDER BY expires_at, id;
+		`,
+		accaunt.ID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed query expired lots of points: %w", err)
+	}
+
+	lots, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (pointLot, error) {
+		lot := pointLot{}
+		err := row.Scan(&lot.id, &lot.remaining, &lot.orderNum)
+		return lot, err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed scan expired lots of points: %w", err)
+	}
+
+	var (
+		total   money.Amount
+		expired int
+	)
+	free := max(accaunt.Balance-accaunt.Held, 0)
+	for _, lot := range lots {
+		if free == 0 {
+			break
+		}
+		take := min(lot.remaining, free)
+		_, err = tx.Exec(
+			ctx,
+			`UPDATE point_lots SET
+				remaining = remaining - $1,
+				expired_at = CASE WHEN remaining = $1 THEN NOW() ELSE expired_at END
+			WHERE
+				id = $2;`,
+			take,
+			lot.id,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed update expired lot of points: %w", err)
+		}
+
+		orderNum := ""
+		if lot.orderNum != nil {
+			orderNum = *lot.orderNum
+		}
+		err = insertPosting(ctx, tx, ledger.Expiry(accaunt.ID, orderNum, take))
+		if err != nil {
+			return 0, fmt.Errorf("failed expire points: %w", err)
+		}
+		total += take
+		free -= take
+		expired++
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		`UPDATE accounts SET
+			balance = balance - $1
+		WHERE
+			id = $2;`,
+		total,
+		accaunt.ID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed update accaunt in expire points transaction: %w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed commits the transaction expire points: %w", err)
+	}
+
+	log.Infof("expired %s points of user %d in %d lots", total, userID, expired)
+	return expired, nil
+}
+
+// expiringPoints returns the points of the accaunt that expire within
+// the notice period and the date the first of them expire.
+func (psg *PostgresStorage) expiringPoints(ctx context.Context, accountID int) (money.Amount, *time.Time, error) {
+	row := psg.pool.QueryRow(
+		ctx,
+		`SELECT COALESCE(SUM(remaining), 0), MIN(expires_at)
+			FROM point_lots
+			WHERE account_id = $1 AND remaining > 0
+				AND expires_at <= NOW() + $2 * INTERVAL '1 millisecond';
+		`,
+		accountID,
+		psg.expiry.Notice.Milliseconds(),
+	)
+
+	var (
+		sum money.Amount
+		at  *time.Time
+	)
+	err := row.Scan(&sum, &at)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to scan row when get expiring points: %w", err)
+	}
+	return sum, at, nil
+}
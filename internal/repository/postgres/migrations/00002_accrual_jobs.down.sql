@@ -0,0 +1,7 @@
+BEGIN TRANSACTION;
+
+DROP INDEX IF EXISTS accrual_jobs_created_at;
+
+DROP TABLE accrual_jobs;
+
+COMMIT;
@@ -0,0 +1,18 @@
+BEGIN TRANSACTION;
+
+CREATE TABLE accrual_jobs(
+    order_num VARCHAR(200) UNIQUE NOT NULL PRIMARY KEY REFERENCES orders (order_num) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    claimed_at TIMESTAMPTZ
+);
+
+INSERT INTO accrual_jobs (order_num)
+SELECT orders.order_num FROM orders
+WHERE orders.order_status IN ('NEW', 'PROCESSING')
+    AND NOT EXISTS (
+        SELECT 1 FROM history WHERE history.order_num = orders.order_num
+    );
+
+CREATE INDEX accrual_jobs_created_at ON accrual_jobs (created_at);
+
+COMMIT;
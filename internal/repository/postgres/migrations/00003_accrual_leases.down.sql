@@ -0,0 +1,9 @@
+BEGIN TRANSACTION;
+
+DROP INDEX IF EXISTS accrual_jobs_locked_until;
+
+ALTER TABLE accrual_jobs DROP COLUMN locked_until;
+ALTER TABLE accrual_jobs DROP COLUMN locked_by;
+ALTER TABLE accrual_jobs ADD COLUMN claimed_at TIMESTAMPTZ;
+
+COMMIT;
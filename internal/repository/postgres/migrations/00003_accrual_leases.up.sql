@@ -0,0 +1,9 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE accrual_jobs DROP COLUMN claimed_at;
+ALTER TABLE accrual_jobs ADD COLUMN locked_by VARCHAR(200);
+ALTER TABLE accrual_jobs ADD COLUMN locked_until TIMESTAMPTZ;
+
+CREATE INDEX accrual_jobs_locked_until ON accrual_jobs (locked_until);
+
+COMMIT;
@@ -0,0 +1,9 @@
+BEGIN TRANSACTION;
+
+DROP INDEX IF EXISTS accrual_jobs_next_attempt_at;
+CREATE INDEX accrual_jobs_created_at ON accrual_jobs (created_at);
+
+ALTER TABLE accrual_jobs DROP COLUMN next_attempt_at;
+ALTER TABLE accrual_jobs DROP COLUMN attempts;
+
+COMMIT;
@@ -0,0 +1,9 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE accrual_jobs ADD COLUMN attempts INT NOT NULL DEFAULT 0;
+ALTER TABLE accrual_jobs ADD COLUMN next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+DROP INDEX IF EXISTS accrual_jobs_created_at;
+CREATE INDEX accrual_jobs_next_attempt_at ON accrual_jobs (next_attempt_at);
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN TRANSACTION;
+
+DROP INDEX IF EXISTS order_status_transitions_order_num;
+
+DROP TABLE order_status_transitions;
+
+COMMIT;
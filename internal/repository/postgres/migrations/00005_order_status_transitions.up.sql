@@ -0,0 +1,13 @@
+BEGIN TRANSACTION;
+
+CREATE TABLE order_status_transitions(
+    id SERIAL UNIQUE NOT NULL PRIMARY KEY,
+    order_num VARCHAR(200) NOT NULL REFERENCES orders (order_num) ON DELETE CASCADE,
+    from_status VARCHAR(200) NOT NULL,
+    to_status VARCHAR(200) NOT NULL,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX order_status_transitions_order_num ON order_status_transitions (order_num);
+
+COMMIT;
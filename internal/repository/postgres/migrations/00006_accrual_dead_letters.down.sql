@@ -0,0 +1,8 @@
+BEGIN TRANSACTION;
+
+DROP INDEX IF EXISTS accrual_jobs_dead_at;
+
+ALTER TABLE accrual_jobs DROP COLUMN dead_at;
+ALTER TABLE accrual_jobs DROP COLUMN last_error;
+
+COMMIT;
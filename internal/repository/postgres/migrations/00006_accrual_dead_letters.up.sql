@@ -0,0 +1,8 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE accrual_jobs ADD COLUMN last_error TEXT NOT NULL DEFAULT '';
+ALTER TABLE accrual_jobs ADD COLUMN dead_at TIMESTAMPTZ;
+
+CREATE INDEX accrual_jobs_dead_at ON accrual_jobs (dead_at);
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN TRANSACTION;
+
+DROP INDEX IF EXISTS accrual_jobs_quarantined_at;
+
+ALTER TABLE accrual_jobs DROP COLUMN quarantined_at;
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE accrual_jobs ADD COLUMN quarantined_at TIMESTAMPTZ;
+
+CREATE INDEX accrual_jobs_quarantined_at ON accrual_jobs (quarantined_at);
+
+COMMIT;
@@ -0,0 +1,5 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE orders ADD COLUMN provider VARCHAR(200) NOT NULL DEFAULT '';
+
+COMMIT;
@@ -0,0 +1,12 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE history
+    ALTER COLUMN sum TYPE DOUBLE PRECISION USING sum::DOUBLE PRECISION;
+
+ALTER TABLE accounts
+    ALTER COLUMN withdrawn DROP NOT NULL,
+    ALTER COLUMN withdrawn DROP DEFAULT,
+    ALTER COLUMN withdrawn TYPE DOUBLE PRECISION USING withdrawn::DOUBLE PRECISION,
+    ALTER COLUMN balance TYPE DOUBLE PRECISION USING balance::DOUBLE PRECISION;
+
+COMMIT;
@@ -0,0 +1,14 @@
+BEGIN TRANSACTION;
+
+UPDATE accounts SET withdrawn = 0 WHERE withdrawn IS NULL;
+
+ALTER TABLE accounts
+    ALTER COLUMN balance TYPE NUMERIC(20, 2) USING ROUND(balance::NUMERIC, 2),
+    ALTER COLUMN withdrawn TYPE NUMERIC(20, 2) USING ROUND(withdrawn::NUMERIC, 2),
+    ALTER COLUMN withdrawn SET DEFAULT 0,
+    ALTER COLUMN withdrawn SET NOT NULL;
+
+ALTER TABLE history
+    ALTER COLUMN sum TYPE NUMERIC(20, 2) USING ROUND(sum::NUMERIC, 2);
+
+COMMIT;
@@ -0,0 +1,47 @@
+BEGIN TRANSACTION;
+
+CREATE TABLE ledger(
+    id BIGSERIAL PRIMARY KEY,
+    kind VARCHAR(50) NOT NULL,
+    debit_account VARCHAR(200) NOT NULL,
+    credit_account VARCHAR(200) NOT NULL,
+    amount NUMERIC(20, 2) NOT NULL CHECK (amount > 0),
+    order_num VARCHAR(200),
+    comment TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK (debit_account <> credit_account)
+);
+
+CREATE INDEX ledger_debit_account ON ledger (debit_account);
+CREATE INDEX ledger_credit_account ON ledger (credit_account);
+CREATE UNIQUE INDEX ledger_accrual_order_num ON ledger (order_num) WHERE kind = 'accrual';
+
+INSERT INTO ledger (kind, debit_account, credit_account, amount, order_num, created_at)
+SELECT
+    'accrual',
+    'system:accrual',
+    'user:' || accounts.id,
+    history.sum,
+    history.order_num,
+    history.item_timestamp
+FROM history
+INNER JOIN orders ON orders.order_num = history.order_num
+INNER JOIN accounts ON accounts.user_id = orders.user_id
+WHERE history.item_type = 'accrual' AND history.sum > 0
+ORDER BY history.id;
+
+INSERT INTO ledger (kind, debit_account, credit_account, amount, order_num, created_at)
+SELECT
+    'withdrawal',
+    'user:' || accounts.id,
+    'system:withdrawals',
+    history.sum,
+    history.order_num,
+    history.item_timestamp
+FROM history
+INNER JOIN orders ON orders.order_num = history.order_num
+INNER JOIN accounts ON accounts.user_id = orders.user_id
+WHERE history.item_type = 'withdrawn' AND history.sum > 0
+ORDER BY history.id;
+
+COMMIT;
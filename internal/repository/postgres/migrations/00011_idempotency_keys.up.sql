@@ -0,0 +1,18 @@
+BEGIN TRANSACTION;
+
+CREATE TABLE idempotency_keys(
+    user_id INT NOT NULL,
+    path VARCHAR(200) NOT NULL,
+    idem_key VARCHAR(255) NOT NULL,
+    request_hash CHAR(64) NOT NULL,
+    status_code INT,
+    header JSONB,
+    body BYTEA,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (user_id, path, idem_key)
+);
+
+CREATE INDEX idempotency_keys_expires_at ON idempotency_keys (expires_at);
+
+COMMIT;
@@ -0,0 +1,9 @@
+BEGIN TRANSACTION;
+
+DELETE FROM history WHERE item_type = 'refund';
+
+DROP INDEX IF EXISTS history_order_num_item;
+
+ALTER TABLE history ADD CONSTRAINT history_order_num_key UNIQUE (order_num);
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE history DROP CONSTRAINT history_order_num_key;
+
+CREATE UNIQUE INDEX history_order_num_item ON history (order_num) WHERE item_type <> 'refund';
+
+COMMIT;
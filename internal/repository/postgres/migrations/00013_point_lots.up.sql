@@ -0,0 +1,25 @@
+BEGIN TRANSACTION;
+
+CREATE TABLE point_lots(
+    id BIGSERIAL PRIMARY KEY,
+    account_id INT NOT NULL,
+    order_num VARCHAR(200),
+    amount NUMERIC(20, 2) NOT NULL CHECK (amount > 0),
+    remaining NUMERIC(20, 2) NOT NULL CHECK (remaining >= 0 AND remaining <= amount),
+    credited_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ,
+    expired_at TIMESTAMPTZ
+);
+
+CREATE INDEX point_lots_account_id ON point_lots (account_id, expires_at) WHERE remaining > 0;
+CREATE INDEX point_lots_expires_at ON point_lots (expires_at) WHERE remaining > 0;
+
+-- points credited before lots were introduced never expire: the balance
+-- becomes one lot without expires_at, while lots credited later expire
+-- once POINTS_EXPIRY_MONTHS is set
+INSERT INTO point_lots (account_id, amount, remaining)
+SELECT id, balance, balance
+FROM accounts
+WHERE balance > 0;
+
+COMMIT;
@@ -0,0 +1,18 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE accounts ADD COLUMN held NUMERIC(20, 2) NOT NULL DEFAULT 0 CHECK (held >= 0);
+
+CREATE TABLE holds(
+    order_num VARCHAR(200) PRIMARY KEY,
+    user_id INT NOT NULL,
+    sum NUMERIC(20, 2) NOT NULL CHECK (sum > 0),
+    status VARCHAR(50) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    finished_at TIMESTAMPTZ
+);
+
+CREATE INDEX holds_user_id ON holds (user_id);
+CREATE INDEX holds_expires_at ON holds (expires_at) WHERE status = 'HELD';
+
+COMMIT;
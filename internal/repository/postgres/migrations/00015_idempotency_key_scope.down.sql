@@ -0,0 +1,12 @@
+BEGIN TRANSACTION;
+
+-- scoped keys of anonymous requests can not be told apart without the scope
+DELETE FROM idempotency_keys WHERE scope <> '';
+
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (user_id, path, idem_key);
+
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS subject_user_id;
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS scope;
+
+COMMIT;
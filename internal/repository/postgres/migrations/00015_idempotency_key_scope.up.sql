@@ -0,0 +1,17 @@
+BEGIN TRANSACTION;
+
+-- anonymous requests share user_id 0, their keys are scoped by the request hash
+ALTER TABLE idempotency_keys ADD COLUMN scope VARCHAR(64) NOT NULL DEFAULT '';
+-- the user a replayed response issues a new token for, tokens are not stored
+ALTER TABLE idempotency_keys ADD COLUMN subject_user_id INT;
+
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (user_id, scope, path, idem_key);
+
+-- stored hashes of registration requests are derived from plaintext passwords
+-- and stored responses carry tokens, the keys live for IDEMPOTENCY_TTL only
+DELETE FROM idempotency_keys WHERE path = '/api/user/register';
+
+UPDATE idempotency_keys SET header = header - 'Authorization' WHERE header ? 'Authorization';
+
+COMMIT;
@@ -0,0 +1,11 @@
+BEGIN TRANSACTION;
+
+CREATE TABLE lot_consumptions(
+    lot_id BIGINT NOT NULL,
+    order_num VARCHAR(200) NOT NULL,
+    amount NUMERIC(20, 2) NOT NULL CHECK (amount > 0),
+    restored NUMERIC(20, 2) NOT NULL DEFAULT 0 CHECK (restored >= 0 AND restored <= amount),
+    PRIMARY KEY (order_num, lot_id)
+);
+
+COMMIT;
@@ -0,0 +1,11 @@
+BEGIN TRANSACTION;
+
+CREATE TABLE webhook_deliveries(
+    signature CHAR(64) PRIMARY KEY,
+    received_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX webhook_deliveries_expires_at ON webhook_deliveries (expires_at);
+
+COMMIT;
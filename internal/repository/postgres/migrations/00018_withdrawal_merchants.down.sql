@@ -0,0 +1,6 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE holds DROP COLUMN IF EXISTS merchant;
+ALTER TABLE history DROP COLUMN IF EXISTS merchant;
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN TRANSACTION;
+
+-- the merchant the points were spent at, only it may refund them
+ALTER TABLE history ADD COLUMN merchant VARCHAR(200);
+ALTER TABLE holds ADD COLUMN merchant VARCHAR(200);
+
+COMMIT;
@@ -0,0 +1,56 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/config"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/logger"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/user"
+)
+
+// newTestStorage connects to the database from TEST_DATABASE_URI
+// and skips the test if it is not set.
+func newTestStorage(t *testing.T) *PostgresStorage {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URI")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+
+	lg := logger.NewLogrusLogger()
+	if err := lg.SetLevelForLog("warn"); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := New(dsn, lg, config.JWTConfig{}, config.ExpiryConfig{Months: config.DefaultExpiryMonths})
+	if err != nil {
+		t.Fatalf("failed create storage: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := store.Close(); err != nil {
+			t.Errorf("failed close storage: %v", err)
+		}
+	})
+	return store
+}
+
+// newTestUser registers a user with a unique login and returns its id
+// and the prefix for unique order numbers of the test.
+func newTestUser(t *testing.T, store *PostgresStorage) (int, string) {
+	t.Helper()
+
+	prefix := fmt.Sprintf("%d", time.Now().UnixNano())
+	userID, err := store.Register(context.Background(), user.User{
+		Login:    "test" + prefix,
+		Password: "password",
+	})
+	if err != nil {
+		t.Fatalf("failed register user: %v", err)
+	}
+	return userID, prefix
+}
@@ -0,0 +1,138 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/ledger"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/money"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/order"
+)
+
+// RefundWithdrawal returns sum of the withdrawal for the order back to
+// the user account, or all the rest of it if sum is zero. The refund is
+// recorded as a compensating history entry and a ledger posting.
+// A non-empty merchant may refund only the withdrawals made at it.
+// It returns pgx.ErrNoRows if there is no such withdrawal for the order and
+// ledger.ErrRefundExceeded if sum is more than the rest of the withdrawal.
+func (psg *PostgresStorage) RefundWithdrawal(
+	ctx context.Context,
+	orderNum, merchant string,
+	sum money.Amount,
+	comment string,
+) (order.Withdraw, error) {
+	log := psg.log.LogrusLog
+
+	tx, err := psg.pool.Begin(ctx)
+	if err != nil {
+		return order.Withdraw{}, fmt.Errorf("failed start refund withdrawal transaction: %w", err)
+	}
+
+	defer func() {
+		errRollback := tx.Rollback(ctx)
+		if errRollback != nil {
+			if !errors.Is(errRollback, pgx.ErrTxClosed) {
+				log.Errorf("failed rolls back refund withdrawal transaction: %v", errRollback)
+			}
+		}
+	}()
+
+	withdraw := order.Withdraw{Number: orderNum}
+	var userID int
+	row := tx.QueryRow(
+		ctx,
+		`SELECT orders.user_id, history.sum, history.item_timestamp, COALESCE(history.merchant, '')
+			FROM history
+			INNER JOIN orders
+			ON orders.order_num = history.order_num
+			WHERE history.order_num = $1 AND history.item_type = 'withdrawn'
+				AND ($2 = '' OR history.merchant = $2);
+		`,
+		orderNum,
+		merchant,
+	)
+	err = row.Scan(&userID, &withdraw.Sum, &withdraw.Timestamp, &withdraw.Merchant)
+	if err != nil {
+		return order.Withdraw{}, fmt.Errorf("failed get withdrawal in refund withdrawal transaction: %w", err)
+	}
+
+	// refunds of the withdrawal are serialized by the lock of its accaunt
+	accaunt, err := lockAccaunt(ctx, tx, userID)
+	if err != nil {
+		return order.Withdraw{}, fmt.Errorf("failed get accaunt in refund withdrawal transaction: %w", err)
+	}
+
+	row = tx.QueryRow(
+		ctx,
+		`SELECT COALESCE(SUM(sum), 0) FROM history
+			WHERE order_num = $1 AND item_type = 'refund';
+		`,
+		orderNum,
+	)
+	err = row.Scan(&withdraw.Refunded)
+	if err != nil {
+		return order.Withdraw{}, fmt.Errorf("failed get refunded sum in refund withdrawal transaction: %w", err)
+	}
+
+	rest := withdraw.Sum - withdraw.Refunded
+	if sum == 0 {
+		sum = rest
+	}
+	if sum <= 0 || sum > rest {
+		return order.Withdraw{}, fmt.Errorf("failed refund withdrawal %s: %w", orderNum, ledger.ErrRefundExceeded)
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		`INSERT INTO history (order_num, item_type, sum)
+		VALUES ($1, $2, $3);`,
+		orderNum,
+		"refund",
+		sum,
+	)
+	if err != nil {
+		return order.Withdraw{}, fmt.Errorf("failed exec query add history item in refund withdrawal transaction: %w", err)
+	}
+
+	err = insertPosting(ctx, tx, ledger.Refund(accaunt.ID, orderNum, sum, comment))
+	if err != nil {
+		return order.Withdraw{}, fmt.Errorf("failed refund withdrawal: %w", err)
+	}
+
+	rest, err = restoreLots(ctx, tx, orderNum, sum)
+	if err != nil {
+		return order.Withdraw{}, fmt.Errorf("failed refund withdrawal: %w", err)
+	}
+
+	// points of withdrawals without consumptions on record are credited as a new lot
+	err = psg.addLot(ctx, tx, accaunt.ID, orderNum, rest)
+	if err != nil {
+		return order.Withdraw{}, fmt.Errorf("failed refund withdrawal: %w", err)
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		`UPDATE accounts SET
+			balance = balance + $1,
+			withdrawn = withdrawn - $1
+		WHERE
+			id = $2;`,
+		sum,
+		accaunt.ID,
+	)
+	if err != nil {
+		return order.Withdraw{}, fmt.Errorf("failed update accaunt in refund withdrawal transaction: %w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return order.Withdraw{}, fmt.Errorf("failed commits the transaction refund withdrawal: %w", err)
+	}
+
+	withdraw.Refunded += sum
+	withdraw.State = order.WithdrawState(withdraw.Sum, withdraw.Refunded)
+	return withdraw, nil
+}
@@ -2,7 +2,6 @@ package postgres
 
 import (
 	"context"
-	"database/sql"
 	"embed"
 	"errors"
 	"fmt"
@@ -16,32 +15,43 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/zhenyanesterkova/gmloyalty/internal/config"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/ledger"
 	"github.com/zhenyanesterkova/gmloyalty/internal/service/logger"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/money"
 	"github.com/zhenyanesterkova/gmloyalty/internal/service/order"
 	"github.com/zhenyanesterkova/gmloyalty/internal/service/user"
 )
 
 type PostgresStorage struct {
-	pool *pgxpool.Pool
-	log  logger.LogrusLogger
+	pool   *pgxpool.Pool
+	log    logger.LogrusLogger
+	expiry config.ExpiryConfig
 }
 
 func New(
 	dsn string,
 	lg logger.LogrusLogger,
 	cfgJWT config.JWTConfig,
+	cfgExpiry config.ExpiryConfig,
 ) (*PostgresStorage, error) {
 	if err := runMigrations(dsn); err != nil {
 		return nil, fmt.Errorf("failed to run DB migrations: %w", err)
 	}
+	return Open(dsn, lg, cfgExpiry)
+}
+
+// Open connects to the database without applying migrations. It is meant
+// for tools that must not change the schema of a running service.
+func Open(dsn string, lg logger.LogrusLogger, cfgExpiry config.ExpiryConfig) (*PostgresStorage, error) {
 	pool, err := pgxpool.New(context.Background(), dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create a connection pool: %w", err)
 	}
 
 	return &PostgresStorage{
-		pool: pool,
-		log:  lg,
+		pool:   pool,
+		log:    lg,
+		expiry: cfgExpiry,
 	}, nil
 }
 
@@ -159,7 +169,7 @@ func (psg *PostgresStorage) Login(userData user.User) (int, error) {
 func (psg *PostgresStorage) GetUserAccaunt(userID int) (user.Accaunt, error) {
 	row := psg.pool.QueryRow(
 		context.TODO(),
-		`SELECT id, balance, withdrawn FROM accounts 
+		`SELECT id, balance, withdrawn, held FROM accounts 
 			WHERE user_id = $1;
 		`,
 		userID,
@@ -167,10 +177,16 @@ func (psg *PostgresStorage) GetUserAccaunt(userID int) (user.Accaunt, error) {
 
 	acc := user.Accaunt{}
 	acc.UserID = userID
-	err := row.Scan(&acc.ID, &acc.Balance, &acc.Withdrawn)
+	err := row.Scan(&acc.ID, &acc.Balance, &acc.Withdrawn, &acc.Held)
 	if err != nil {
 		return user.Accaunt{}, fmt.Errorf("failed to scan row when get user accaunt by userID: %w", err)
 	}
+	acc.Available = acc.Balance - acc.Held
+
+	acc.ExpiringSoon, acc.ExpiringAt, err = psg.expiringPoints(context.TODO(), acc.ID)
+	if err != nil {
+		return user.Accaunt{}, fmt.Errorf("failed get user accaunt: %w", err)
+	}
 
 	return acc, nil
 }
@@ -203,8 +219,25 @@ func (psg *PostgresStorage) GetOrderByOrderNum(orderNum string) (order.Order, er
 }
 
 func (psg *PostgresStorage) AddOrder(orderData order.Order) error {
-	_, err := psg.pool.Exec(
-		context.TODO(),
+	log := psg.log.LogrusLog
+	ctx := context.TODO()
+
+	tx, err := psg.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed start add order transaction: %w", err)
+	}
+
+	defer func() {
+		errRollback := tx.Rollback(ctx)
+		if errRollback != nil {
+			if !errors.Is(errRollback, pgx.ErrTxClosed) {
+				log.Errorf("failed rolls back add order transaction: %v", errRollback)
+			}
+		}
+	}()
+
+	_, err = tx.Exec(
+		ctx,
 		`INSERT INTO orders (order_num, user_id, order_status)
 			VALUES ($1, $2, $3);`,
 		orderData.Number,
@@ -214,41 +247,80 @@ func (psg *PostgresStorage) AddOrder(orderData order.Order) error {
 	if err != nil {
 		return fmt.Errorf("failed add order to orders: %w", err)
 	}
+
+	_, err = tx.Exec(
+		ctx,
+		`INSERT INTO accrual_jobs (order_num)
+			VALUES ($1)
+			ON CONFLICT (order_num) DO NOTHING;`,
+		orderData.Number,
+	)
+	if err != nil {
+		return fmt.Errorf("failed add accrual job in add order transaction: %w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed commits the transaction add order: %w", err)
+	}
 	return nil
 }
 
 func (psg *PostgresStorage) UpdateOrderStatus(orderData order.Order) error {
-	_, err := psg.pool.Exec(
-		context.TODO(),
-		`UPDATE orders SET
-			order_status = $1
-		WHERE 
-			order_num = $2;`,
-		orderData.Status,
-		orderData.Number,
-	)
+	log := psg.log.LogrusLog
+	ctx := context.TODO()
+
+	tx, err := psg.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed start update order status transaction: %w", err)
+	}
+
+	defer func() {
+		errRollback := tx.Rollback(ctx)
+		if errRollback != nil {
+			if !errors.Is(errRollback, pgx.ErrTxClosed) {
+				log.Errorf("failed rolls back update order status transaction: %v", errRollback)
+			}
+		}
+	}()
+
+	currentStatus, err := lockOrderStatus(ctx, tx, orderData.Number)
 	if err != nil {
-		return fmt.Errorf("failed update order in orders: %w", err)
+		return fmt.Errorf("failed get order status in update order status transaction: %w", err)
+	}
+
+	transition, err := order.NewTransition(orderData.Number, currentStatus, orderData.Status)
+	if err != nil {
+		return fmt.Errorf("failed update order status: %w", err)
+	}
+
+	err = applyTransition(ctx, tx, transition)
+	if err != nil {
+		return fmt.Errorf("failed apply transition in update order status transaction: %w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed commits the transaction update order status: %w", err)
 	}
 	return nil
 }
 
+// ProcessingOrder moves the order to its final status and credits the accrual.
+// The order and accaunt rows are locked and the balance is updated relative
+// to its current value, so concurrent withdrawals and accruals are not lost.
 func (psg *PostgresStorage) ProcessingOrder(ctx context.Context, orderData order.Order) error {
 	log := psg.log.LogrusLog
 
 	log.Debug("start save info about order to DB ...")
 
 	log.WithFields(logrus.Fields{
-		"Number":  orderData.Number,
-		"Status":  orderData.Status,
-		"Accrual": orderData.Accrual,
+		"Number":   orderData.Number,
+		"Status":   orderData.Status,
+		"Accrual":  orderData.Accrual,
+		"Provider": orderData.Provider,
 	}).Info("set order info")
 
-	accaunt, err := psg.GetUserAccaunt(orderData.UserID)
-	if err != nil {
-		return fmt.Errorf("failed get accaunt to process order: %w", err)
-	}
-
 	tx, err := psg.pool.Begin(ctx)
 	if err != nil {
 		return fmt.Errorf("failed start a processing order transaction: %w", err)
@@ -264,9 +336,66 @@ func (psg *PostgresStorage) ProcessingOrder(ctx context.Context, orderData order
 	}()
 
 	_, err = tx.Exec(
+		ctx,
+		`DELETE FROM accrual_jobs
+		WHERE 
+			order_num = $1;`,
+		orderData.Number,
+	)
+	if err != nil {
+		return fmt.Errorf("failed delete accrual job in processing order transaction: %w", err)
+	}
+
+	currentStatus, err := lockOrderStatus(ctx, tx, orderData.Number)
+	if err != nil {
+		return fmt.Errorf("failed get order status in processing order transaction: %w", err)
+	}
+
+	if order.IsFinal(currentStatus) {
+		log.Warnf("order %s has already been processed, skip accrual", orderData.Number)
+		err = tx.Commit(ctx)
+		if err != nil {
+			return fmt.Errorf("failed commits the transaction processing order: %w", err)
+		}
+		return nil
+	}
+
+	transition, err := order.NewTransition(orderData.Number, currentStatus, orderData.Status)
+	if err != nil {
+		return fmt.Errorf("failed processing order: %w", err)
+	}
+
+	err = applyTransition(ctx, tx, transition)
+	if err != nil {
+		return fmt.Errorf("failed apply transition in processing order transaction: %w", err)
+	}
+
+	if orderData.Provider != "" {
+		_, err = tx.Exec(
+			ctx,
+			`UPDATE orders SET provider = $1
+			WHERE order_num = $2;`,
+			orderData.Provider,
+			orderData.Number,
+		)
+		if err != nil {
+			return fmt.Errorf("failed set provider in processing order transaction: %w", err)
+		}
+	}
+
+	if transition.To == order.StatusInvalid {
+		err = tx.Commit(ctx)
+		if err != nil {
+			return fmt.Errorf("failed commits the transaction processing order: %w", err)
+		}
+		return nil
+	}
+
+	tag, err := tx.Exec(
 		ctx,
 		`INSERT INTO history (order_num, item_type, sum) 
-		VALUES ($1, $2, $3);`,
+		VALUES ($1, $2, $3)
+		ON CONFLICT (order_num) WHERE item_type <> 'refund' DO NOTHING;`,
 		orderData.Number,
 		"accrual",
 		orderData.Accrual,
@@ -275,32 +404,43 @@ func (psg *PostgresStorage) ProcessingOrder(ctx context.Context, orderData order
 		return fmt.Errorf("failed exec query add history item in processing order transaction: %w", err)
 	}
 
+	if tag.RowsAffected() == 0 {
+		log.Warnf("order %s has already been credited, skip accrual", orderData.Number)
+		err = tx.Commit(ctx)
+		if err != nil {
+			return fmt.Errorf("failed commits the transaction processing order: %w", err)
+		}
+		return nil
+	}
+
+	accaunt, err := lockAccaunt(ctx, tx, orderData.UserID)
+	if err != nil {
+		return fmt.Errorf("failed get accaunt in processing order transaction: %w", err)
+	}
+
+	err = insertPosting(ctx, tx, ledger.Accrual(accaunt.ID, orderData.Number, orderData.Accrual))
+	if err != nil {
+		return fmt.Errorf("failed processing order: %w", err)
+	}
+
+	err = psg.addLot(ctx, tx, accaunt.ID, orderData.Number, orderData.Accrual)
+	if err != nil {
+		return fmt.Errorf("failed processing order: %w", err)
+	}
+
 	_, err = tx.Exec(
 		ctx,
 		`UPDATE accounts SET
-			balance = $1
+			balance = balance + $1
 		WHERE 
 			id = $2;`,
-		accaunt.Balance+orderData.Accrual,
+		orderData.Accrual,
 		accaunt.ID,
 	)
 	if err != nil {
 		return fmt.Errorf("failed exec query update user accaut in processing order transaction: %w", err)
 	}
 
-	_, err = tx.Exec(
-		ctx,
-		`UPDATE orders SET
-			order_status = $1
-		WHERE 
-			order_num = $2;`,
-		orderData.Status,
-		orderData.Number,
-	)
-	if err != nil {
-		return fmt.Errorf("failed update order in orders in processing order transaction: %w", err)
-	}
-
 	err = tx.Commit(ctx)
 	if err != nil {
 		return fmt.Errorf("failed commits the transaction processing order: %w", err)
@@ -316,10 +456,10 @@ func (psg *PostgresStorage) GetOrderList(userID int) ([]order.Order, error) {
 			orders.order_status, 
 			orders.upload_time, 
 			orders.user_id, 
-			history.sum
+			COALESCE(history.sum, 0)
 		FROM orders
 		LEFT JOIN history
-		ON orders.order_num = history.order_num AND history.item_type != 'withdrawn'
+		ON orders.order_num = history.order_num AND history.item_type = 'accrual'
 		WHERE orders.user_id = $1 
 		ORDER BY orders.upload_time DESC;
 		`,
@@ -336,7 +476,7 @@ func (psg *PostgresStorage) GetOrderList(userID int) ([]order.Order, error) {
 		orderStatus  string
 		uploadTime   time.Time
 		userIDFromDB int
-		sum          sql.NullFloat64
+		sum          money.Amount
 	)
 	for rows.Next() {
 		err := rows.Scan(
@@ -354,21 +494,20 @@ func (psg *PostgresStorage) GetOrderList(userID int) ([]order.Order, error) {
 			Status:     orderStatus,
 			UploadTime: uploadTime,
 			UserID:     userIDFromDB,
-			Accrual:    sum.Float64,
+			Accrual:    sum,
 		})
 	}
 
 	return orderList, nil
 }
 
+// Withdraw debits the user accaunt. The accaunt row is locked for the whole
+// transaction, so the balance check and the debit can not be interleaved
+// with other withdrawals or accruals. It returns ledger.ErrInsufficientFunds
+// if the balance is less than the sum.
 func (psg *PostgresStorage) Withdraw(ctx context.Context, userID int, withdrawInst order.Withdraw) error {
 	log := psg.log.LogrusLog
 
-	accaunt, err := psg.GetUserAccaunt(userID)
-	if err != nil {
-		return fmt.Errorf("failed get accaunt to add withdraw: %w", err)
-	}
-
 	tx, err := psg.pool.Begin(ctx)
 	if err != nil {
 		return fmt.Errorf("failed start add withdraw transaction: %w", err)
@@ -383,43 +522,27 @@ func (psg *PostgresStorage) Withdraw(ctx context.Context, userID int, withdrawIn
 		}
 	}()
 
-	_, err = tx.Exec(
-		ctx,
-		`INSERT INTO orders (order_num, user_id, order_status)
-			VALUES ($1, $2, $3);`,
-		withdrawInst.Number,
-		userID,
-		order.StatusNew,
-	)
+	accaunt, err := lockAccaunt(ctx, tx, userID)
 	if err != nil {
-		return fmt.Errorf("failed add order to orders in add withdraw transaction: %w", err)
+		return fmt.Errorf("failed get accaunt in add withdraw transaction: %w", err)
 	}
 
-	_, err = tx.Exec(
+	var held bool
+	err = tx.QueryRow(
 		ctx,
-		`INSERT INTO history (order_num, item_type, sum) 
-		VALUES ($1, $2, $3);`,
+		`SELECT EXISTS(SELECT 1 FROM holds WHERE order_num = $1);`,
 		withdrawInst.Number,
-		"withdrawn",
-		withdrawInst.Sum,
-	)
+	).Scan(&held)
 	if err != nil {
-		return fmt.Errorf("failed exec query add history item in add withdraw transaction: %w", err)
+		return fmt.Errorf("failed check holds in add withdraw transaction: %w", err)
+	}
+	if held {
+		return fmt.Errorf("failed add withdraw: %w", order.ErrOrderExists)
 	}
 
-	_, err = tx.Exec(
-		ctx,
-		`UPDATE accounts SET
-			balance = $1,
-			withdrawn = $2
-		WHERE 
-			id = $3;`,
-		accaunt.Balance-withdrawInst.Sum,
-		accaunt.Withdrawn+withdrawInst.Sum,
-		accaunt.ID,
-	)
+	err = withdraw(ctx, tx, accaunt, withdrawInst)
 	if err != nil {
-		return fmt.Errorf("failed exec query update user accaunt in add withdraw transaction: %w", err)
+		return err
 	}
 
 	err = tx.Commit(ctx)
@@ -435,10 +558,18 @@ func (psg *PostgresStorage) Withdrawals(ctx context.Context, userID int) ([]orde
 		`SELECT 
 			history.order_num, 
 			history.sum,
-			history.item_timestamp
+			history.item_timestamp,
+			COALESCE(history.merchant, ''),
+			COALESCE(refunds.sum, 0)
 		FROM history
 		INNER JOIN orders
 		ON orders.order_num = history.order_num AND history.item_type = 'withdrawn'
+		LEFT JOIN (
+			SELECT order_num, SUM(sum) AS sum FROM history
+			WHERE item_type = 'refund'
+			GROUP BY order_num
+		) AS refunds
+		ON refunds.order_num = history.order_num
 		WHERE orders.user_id = $1
 		ORDER BY orders.upload_time DESC;
 		`,
@@ -453,13 +584,17 @@ func (psg *PostgresStorage) Withdrawals(ctx context.Context, userID int) ([]orde
 	var (
 		orderNum   string
 		uploadTime time.Time
-		sum        sql.NullFloat64
+		merchant   string
+		sum        money.Amount
+		refunded   money.Amount
 	)
 	for rows.Next() {
 		err := rows.Scan(
 			&orderNum,
 			&sum,
 			&uploadTime,
+			&merchant,
+			&refunded,
 		)
 		if err != nil {
 			return []order.Withdraw{}, fmt.Errorf("failed scan rows when get withdrawals: %w", err)
@@ -467,7 +602,10 @@ func (psg *PostgresStorage) Withdrawals(ctx context.Context, userID int) ([]orde
 		withdrawals = append(withdrawals, order.Withdraw{
 			Number:    orderNum,
 			Timestamp: uploadTime,
-			Sum:       sum.Float64,
+			Merchant:  merchant,
+			Sum:       sum,
+			Refunded:  refunded,
+			State:     order.WithdrawState(sum, refunded),
 		})
 	}
 
@@ -0,0 +1,201 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/ledger"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/money"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/order"
+)
+
+func TestWithdrawConcurrent(t *testing.T) {
+	store := newTestStorage(t)
+	userID, prefix := newTestUser(t, store)
+	ctx := context.Background()
+
+	const calls = 20
+	balance := money.FromCents(1000)
+	sum := money.FromCents(300)
+
+	err := store.AdjustBalance(ctx, userID, balance, "test")
+	if err != nil {
+		t.Fatalf("failed credit balance: %v", err)
+	}
+
+	errs := make([]error, calls)
+	var wg sync.WaitGroup
+	for i := range calls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = store.Withdraw(ctx, userID, order.Withdraw{
+				Number: fmt.Sprintf("%s%02d", prefix, i),
+				Sum:    sum,
+			})
+		}()
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, ledger.ErrInsufficientFunds):
+			t.Errorf("unexpected withdraw error: %v", err)
+		}
+	}
+	if want := int(balance.Cents() / sum.Cents()); succeeded != want {
+		t.Errorf("succeeded withdrawals = %d, want %d", succeeded, want)
+	}
+
+	accaunt, err := store.GetUserAccaunt(userID)
+	if err != nil {
+		t.Fatalf("failed get accaunt: %v", err)
+	}
+	if accaunt.Balance < 0 {
+		t.Errorf("balance = %s, want non-negative", accaunt.Balance)
+	}
+	if want := balance - money.FromCents(int64(succeeded)*sum.Cents()); accaunt.Balance != want {
+		t.Errorf("balance = %s, want %s", accaunt.Balance, want)
+	}
+}
+
+func TestAccrualsAndWithdrawalsAgreeWithLedger(t *testing.T) {
+	store := newTestStorage(t)
+	userID, prefix := newTestUser(t, store)
+	ctx := context.Background()
+
+	const (
+		accruals    = 50
+		withdrawals = 50
+	)
+	accrual := money.FromCents(250)
+	sum := money.FromCents(400)
+
+	for i := range accruals {
+		err := store.AddOrder(order.Order{
+			Number: fmt.Sprintf("%sa%03d", prefix, i),
+			UserID: userID,
+			Status: order.StatusNew,
+		})
+		if err != nil {
+			t.Fatalf("failed add order: %v", err)
+		}
+	}
+
+	var (
+		wg        sync.WaitGroup
+		withdrawn atomic.Int64
+	)
+	for i := range accruals {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := store.ProcessingOrder(ctx, order.Order{
+				Number:  fmt.Sprintf("%sa%03d", prefix, i),
+				UserID:  userID,
+				Status:  order.StatusProcessed,
+				Accrual: accrual,
+			})
+			if err != nil {
+				t.Errorf("failed processing order: %v", err)
+			}
+		}()
+	}
+	for i := range withdrawals {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := store.Withdraw(ctx, userID, order.Withdraw{
+				Number: fmt.Sprintf("%sw%03d", prefix, i),
+				Sum:    sum,
+			})
+			switch {
+			case err == nil:
+				withdrawn.Add(sum.Cents())
+			case !errors.Is(err, ledger.ErrInsufficientFunds):
+				t.Errorf("unexpected withdraw error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	accaunt, err := store.GetUserAccaunt(userID)
+	if err != nil {
+		t.Fatalf("failed get accaunt: %v", err)
+	}
+	wantBalance := money.FromCents(accruals*accrual.Cents() - withdrawn.Load())
+	if accaunt.Balance != wantBalance {
+		t.Errorf("balance = %s, want %s", accaunt.Balance, wantBalance)
+	}
+	if want := money.FromCents(withdrawn.Load()); accaunt.Withdrawn != want {
+		t.Errorf("withdrawn = %s, want %s", accaunt.Withdrawn, want)
+	}
+
+	discrepancies, err := store.Reconcile(ctx)
+	if err != nil {
+		t.Fatalf("failed reconcile: %v", err)
+	}
+	for _, d := range discrepancies {
+		if d.UserID == userID {
+			t.Errorf("accaunt disagrees with the ledger: %+v", d)
+		}
+	}
+}
+
+func TestRefundWithdrawalOfOtherMerchant(t *testing.T) {
+	store := newTestStorage(t)
+	userID, prefix := newTestUser(t, store)
+	ctx := context.Background()
+
+	err := store.AdjustBalance(ctx, userID, money.FromCents(1000), "test")
+	if err != nil {
+		t.Fatalf("failed credit balance: %v", err)
+	}
+	err = store.Withdraw(ctx, userID, order.Withdraw{
+		Number:   prefix,
+		Sum:      money.FromCents(500),
+		Merchant: "shop",
+	})
+	if err != nil {
+		t.Fatalf("failed withdraw: %v", err)
+	}
+
+	_, err = store.RefundWithdrawal(ctx, prefix, "other", money.FromCents(100), "test")
+	if !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("refund by other merchant: err = %v, want %v", err, pgx.ErrNoRows)
+	}
+
+	withdraw, err := store.RefundWithdrawal(ctx, prefix, "shop", money.FromCents(100), "test")
+	if err != nil {
+		t.Fatalf("refund by merchant of withdrawal: %v", err)
+	}
+	if withdraw.Merchant != "shop" || withdraw.Refunded != money.FromCents(100) {
+		t.Errorf("withdrawal at %q refunded %s, want at shop refunded 1.00", withdraw.Merchant, withdraw.Refunded)
+	}
+}
+
+func TestAmountNumericRoundTrip(t *testing.T) {
+	store := newTestStorage(t)
+	ctx := context.Background()
+
+	amounts := []money.Amount{0, 1, -1, 72998, -72998, money.FromCents(9_223_372_036_854_775_807)}
+	for _, amount := range amounts {
+		var got money.Amount
+		err := store.pool.QueryRow(ctx, `SELECT $1::NUMERIC(20,2);`, amount).Scan(&got)
+		if err != nil {
+			t.Fatalf("failed round trip %s: %v", amount, err)
+		}
+		if got != amount {
+			t.Errorf("round trip of %s = %s", amount, got)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/order"
+)
+
+// lockOrderStatus returns the current status of the order and locks
+// the order row until the end of the transaction.
+func lockOrderStatus(ctx context.Context, tx pgx.Tx, orderNum string) (string, error) {
+	row := tx.QueryRow(
+		ctx,
+		`SELECT order_status FROM orders
+			WHERE order_num = $1
+			FOR UPDATE;
+		`,
+		orderNum,
+	)
+
+	var status string
+	err := row.Scan(&status)
+	if err != nil {
+		return "", fmt.Errorf("failed to scan row when lock order status: %w", err)
+	}
+	return status, nil
+}
+
+func applyTransition(ctx context.Context, tx pgx.Tx, transition order.Transition) error {
+	_, err := tx.Exec(
+		ctx,
+		`UPDATE orders SET
+			order_status = $1
+		WHERE 
+			order_num = $2;`,
+		transition.To,
+		transition.Number,
+	)
+	if err != nil {
+		return fmt.Errorf("failed update order status: %w", err)
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		`INSERT INTO order_status_transitions (order_num, from_status, to_status, changed_at)
+			VALUES ($1, $2, $3, $4);`,
+		transition.Number,
+		transition.From,
+		transition.To,
+		transition.At,
+	)
+	if err != nil {
+		return fmt.Errorf("failed add order status transition: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// RecordWebhookDelivery remembers the signature of a callback for ttl.
+// It returns false if a callback with the same signature has already
+// been received and has not expired yet.
+func (psg *PostgresStorage) RecordWebhookDelivery(ctx context.Context, signature string, ttl time.Duration) (bool, error) {
+	tag, err := psg.pool.Exec(
+		ctx,
+		`INSERT INTO webhook_deliveries (signature, expires_at)
+			VALUES ($1, NOW() + $2 * INTERVAL '1 millisecond')
+		ON CONFLICT (signature) DO UPDATE SET
+			received_at = NOW(),
+			expires_at = EXCLUDED.expires_at
+		WHERE webhook_deliveries.expires_at < NOW();`,
+		signature,
+		ttl.Milliseconds(),
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed record webhook delivery: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (psg *PostgresStorage) PurgeWebhookDeliveries(ctx context.Context) (int64, error) {
+	tag, err := psg.pool.Exec(
+		ctx,
+		`DELETE FROM webhook_deliveries
+		WHERE
+			expires_at < NOW();`,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed purge expired webhook deliveries: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
@@ -3,10 +3,13 @@ package repository
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/zhenyanesterkova/gmloyalty/internal/config"
 	"github.com/zhenyanesterkova/gmloyalty/internal/repository/postgres"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/idempotency"
 	"github.com/zhenyanesterkova/gmloyalty/internal/service/logger"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/money"
 	"github.com/zhenyanesterkova/gmloyalty/internal/service/order"
 	"github.com/zhenyanesterkova/gmloyalty/internal/service/user"
 )
@@ -24,14 +27,43 @@ type Store interface {
 	GetUserAccaunt(userID int) (user.Accaunt, error)
 	Withdraw(ctx context.Context, userID int, withdrawInst order.Withdraw) error
 	Withdrawals(ctx context.Context, userID int) ([]order.Withdraw, error)
+	ClaimAccrualJobs(ctx context.Context, owner string, lease time.Duration, limit int) ([]order.AccrualJob, error)
+	ReleaseAccrualJob(ctx context.Context, owner string, job order.AccrualJob) error
+	RenewAccrualJobLease(ctx context.Context, owner string, orderNum string, lease time.Duration) (bool, error)
+	ResetAccrualJobs(ctx context.Context, owner string) error
+	DeadLetterAccrualJob(ctx context.Context, owner string, job order.AccrualJob) error
+	GetAccrualJob(ctx context.Context, orderNum string) (order.AccrualJob, error)
+	DeadAccrualJobs(ctx context.Context) ([]order.AccrualJob, error)
+	RequeueAccrualJob(ctx context.Context, orderNum string) error
+	QuarantineAccrualJob(ctx context.Context, orderNum, reason string) error
+	QuarantinedAccrualJobs(ctx context.Context) ([]order.AccrualJob, error)
+	AdjustBalance(ctx context.Context, userID int, amount money.Amount, comment string) error
+	ExpirePoints(ctx context.Context) (int, error)
+	HoldPoints(ctx context.Context, userID int, hold order.Hold, timeout time.Duration) (order.Hold, error)
+	CaptureHold(ctx context.Context, userID int, orderNum string) (order.Hold, error)
+	ReleaseHold(ctx context.Context, userID int, orderNum string) (order.Hold, error)
+	ReleaseExpiredHolds(ctx context.Context) (int, error)
+	RefundWithdrawal(
+		ctx context.Context,
+		orderNum, merchant string,
+		sum money.Amount,
+		comment string,
+	) (order.Withdraw, error)
+	ReserveIdempotencyKey(ctx context.Context, key idempotency.Key, ttl time.Duration) (idempotency.Record, bool, error)
+	SaveIdempotentResponse(ctx context.Context, key idempotency.Key, resp idempotency.Response) error
+	ReleaseIdempotencyKey(ctx context.Context, key idempotency.Key) error
+	PurgeIdempotencyKeys(ctx context.Context) (int64, error)
+	RecordWebhookDelivery(ctx context.Context, signature string, ttl time.Duration) (bool, error)
+	PurgeWebhookDeliveries(ctx context.Context) (int64, error)
 }
 
 func NewStore(
 	conf config.DBConfig,
 	log logger.LogrusLogger,
 	cfgJWT config.JWTConfig,
+	cfgExpiry config.ExpiryConfig,
 ) (Store, error) {
-	store, err := postgres.New(conf.DSN, log, cfgJWT)
+	store, err := postgres.New(conf.DSN, log, cfgJWT, cfgExpiry)
 	if err != nil {
 		return nil, fmt.Errorf("failed create postgres storage: %w", err)
 	}
@@ -8,7 +8,9 @@ import (
 	"github.com/zhenyanesterkova/gmloyalty/internal/config"
 	"github.com/zhenyanesterkova/gmloyalty/internal/repository"
 	"github.com/zhenyanesterkova/gmloyalty/internal/service/backoff"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/idempotency"
 	"github.com/zhenyanesterkova/gmloyalty/internal/service/logger"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/money"
 	"github.com/zhenyanesterkova/gmloyalty/internal/service/order"
 	"github.com/zhenyanesterkova/gmloyalty/internal/service/user"
 )
@@ -26,6 +28,7 @@ func New(
 	bf *backoff.Backoff,
 	checkRetryFunc func(error) bool,
 	cfgJWT config.JWTConfig,
+	cfgExpiry config.ExpiryConfig,
 ) (
 	*RetryStorage,
 	error,
@@ -36,11 +39,11 @@ func New(
 		logger:     loggerInst,
 	}
 
-	store, err := repository.NewStore(cfg, loggerInst, cfgJWT)
+	store, err := repository.NewStore(cfg, loggerInst, cfgJWT, cfgExpiry)
 	if err != nil {
 		if retryStore.checkRetry(err) {
 			err = retryStore.retry(func() error {
-				store, err = repository.NewStore(cfg, loggerInst, cfgJWT)
+				store, err = repository.NewStore(cfg, loggerInst, cfgJWT, cfgExpiry)
 				if err != nil {
 					return fmt.Errorf("failed retry create storage: %w", err)
 				}
@@ -226,6 +229,152 @@ func (rs *RetryStorage) Withdrawals(ctx context.Context, userID int) ([]order.Wi
 	return withdrawals, nil
 }
 
+func (rs *RetryStorage) ClaimAccrualJobs(
+	ctx context.Context,
+	owner string,
+	lease time.Duration,
+	limit int,
+) ([]order.AccrualJob, error) {
+	jobs, err := rs.storage.ClaimAccrualJobs(ctx, owner, lease, limit)
+	if rs.checkRetry(err) {
+		err = rs.retry(func() error {
+			jobs, err = rs.storage.ClaimAccrualJobs(ctx, owner, lease, limit)
+			if err != nil {
+				return fmt.Errorf("failed retry claim accrual jobs: %w", err)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return []order.AccrualJob{}, fmt.Errorf("failed claim accrual jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+func (rs *RetryStorage) RenewAccrualJobLease(
+	ctx context.Context,
+	owner string,
+	orderNum string,
+	lease time.Duration,
+) (bool, error) {
+	ok, err := rs.storage.RenewAccrualJobLease(ctx, owner, orderNum, lease)
+	if rs.checkRetry(err) {
+		err = rs.retry(func() error {
+			ok, err = rs.storage.RenewAccrualJobLease(ctx, owner, orderNum, lease)
+			if err != nil {
+				return fmt.Errorf("failed retry renew accrual job lease: %w", err)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed renew accrual job lease: %w", err)
+	}
+	return ok, nil
+}
+
+func (rs *RetryStorage) ReleaseAccrualJob(ctx context.Context, owner string, job order.AccrualJob) error {
+	err := rs.storage.ReleaseAccrualJob(ctx, owner, job)
+	if rs.checkRetry(err) {
+		err = rs.retry(func() error {
+			err = rs.storage.ReleaseAccrualJob(ctx, owner, job)
+			if err != nil {
+				return fmt.Errorf("failed retry release accrual job: %w", err)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed release accrual job: %w", err)
+	}
+	return nil
+}
+
+func (rs *RetryStorage) ResetAccrualJobs(ctx context.Context, owner string) error {
+	err := rs.storage.ResetAccrualJobs(ctx, owner)
+	if rs.checkRetry(err) {
+		err = rs.retry(func() error {
+			err = rs.storage.ResetAccrualJobs(ctx, owner)
+			if err != nil {
+				return fmt.Errorf("failed retry reset accrual jobs: %w", err)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed reset accrual jobs: %w", err)
+	}
+	return nil
+}
+
+func (rs *RetryStorage) DeadLetterAccrualJob(ctx context.Context, owner string, job order.AccrualJob) error {
+	err := rs.storage.DeadLetterAccrualJob(ctx, owner, job)
+	if rs.checkRetry(err) {
+		err = rs.retry(func() error {
+			err = rs.storage.DeadLetterAccrualJob(ctx, owner, job)
+			if err != nil {
+				return fmt.Errorf("failed retry move accrual job to dead letters: %w", err)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed move accrual job to dead letters: %w", err)
+	}
+	return nil
+}
+
+func (rs *RetryStorage) GetAccrualJob(ctx context.Context, orderNum string) (order.AccrualJob, error) {
+	job, err := rs.storage.GetAccrualJob(ctx, orderNum)
+	if rs.checkRetry(err) {
+		err = rs.retry(func() error {
+			job, err = rs.storage.GetAccrualJob(ctx, orderNum)
+			if err != nil {
+				return fmt.Errorf("failed retry get accrual job: %w", err)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return order.AccrualJob{}, fmt.Errorf("failed get accrual job: %w", err)
+	}
+	return job, nil
+}
+
+func (rs *RetryStorage) DeadAccrualJobs(ctx context.Context) ([]order.AccrualJob, error) {
+	jobs, err := rs.storage.DeadAccrualJobs(ctx)
+	if rs.checkRetry(err) {
+		err = rs.retry(func() error {
+			jobs, err = rs.storage.DeadAccrualJobs(ctx)
+			if err != nil {
+				return fmt.Errorf("failed retry get dead accrual jobs: %w", err)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return []order.AccrualJob{}, fmt.Errorf("failed get dead accrual jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+func (rs *RetryStorage) RequeueAccrualJob(ctx context.Context, orderNum string) error {
+	err := rs.storage.RequeueAccrualJob(ctx, orderNum)
+	if rs.checkRetry(err) {
+		err = rs.retry(func() error {
+			err = rs.storage.RequeueAccrualJob(ctx, orderNum)
+			if err != nil {
+				return fmt.Errorf("failed retry requeue accrual job: %w", err)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed requeue accrual job: %w", err)
+	}
+	return nil
+}
+
 func (rs *RetryStorage) Ping() error {
 	err := rs.storage.Ping()
 	if rs.checkRetry(err) {
@@ -270,3 +419,255 @@ func (rs *RetryStorage) retry(work func() error) error {
 		}
 	}
 }
+
+func (rs *RetryStorage) QuarantineAccrualJob(ctx context.Context, orderNum, reason string) error {
+	err := rs.storage.QuarantineAccrualJob(ctx, orderNum, reason)
+	if rs.checkRetry(err) {
+		err = rs.retry(func() error {
+			err = rs.storage.QuarantineAccrualJob(ctx, orderNum, reason)
+			if err != nil {
+				return fmt.Errorf("failed retry quarantine accrual job: %w", err)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed quarantine accrual job: %w", err)
+	}
+	return nil
+}
+
+func (rs *RetryStorage) QuarantinedAccrualJobs(ctx context.Context) ([]order.AccrualJob, error) {
+	jobs, err := rs.storage.QuarantinedAccrualJobs(ctx)
+	if rs.checkRetry(err) {
+		err = rs.retry(func() error {
+			jobs, err = rs.storage.QuarantinedAccrualJobs(ctx)
+			if err != nil {
+				return fmt.Errorf("failed retry get quarantined accrual jobs: %w", err)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return []order.AccrualJob{}, fmt.Errorf("failed get quarantined accrual jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+// AdjustBalance is not retried: an adjustment has no key to tell
+// a repeated one apart, so a retry after a lost commit would apply it twice.
+func (rs *RetryStorage) AdjustBalance(ctx context.Context, userID int, amount money.Amount, comment string) error {
+	err := rs.storage.AdjustBalance(ctx, userID, amount, comment)
+	if err != nil {
+		return fmt.Errorf("failed adjust balance: %w", err)
+	}
+	return nil
+}
+
+// RefundWithdrawal is not retried for the same reason as AdjustBalance:
+// partial refunds of one order can not be told apart from a repeated one.
+func (rs *RetryStorage) RefundWithdrawal(
+	ctx context.Context,
+	orderNum, merchant string,
+	sum money.Amount,
+	comment string,
+) (order.Withdraw, error) {
+	withdraw, err := rs.storage.RefundWithdrawal(ctx, orderNum, merchant, sum, comment)
+	if err != nil {
+		return order.Withdraw{}, fmt.Errorf("failed refund withdrawal: %w", err)
+	}
+	return withdraw, nil
+}
+
+func (rs *RetryStorage) ExpirePoints(ctx context.Context) (int, error) {
+	expired, err := rs.storage.ExpirePoints(ctx)
+	if rs.checkRetry(err) {
+		err = rs.retry(func() error {
+			expired, err = rs.storage.ExpirePoints(ctx)
+			if err != nil {
+				return fmt.Errorf("failed retry expire points: %w", err)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed expire points: %w", err)
+	}
+	return expired, nil
+}
+
+func (rs *RetryStorage) HoldPoints(
+	ctx context.Context,
+	userID int,
+	hold order.Hold,
+	timeout time.Duration,
+) (order.Hold, error) {
+	res, err := rs.storage.HoldPoints(ctx, userID, hold, timeout)
+	if rs.checkRetry(err) {
+		err = rs.retry(func() error {
+			res, err = rs.storage.HoldPoints(ctx, userID, hold, timeout)
+			if err != nil {
+				return fmt.Errorf("failed retry hold points: %w", err)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return order.Hold{}, fmt.Errorf("failed hold points: %w", err)
+	}
+	return res, nil
+}
+
+func (rs *RetryStorage) CaptureHold(ctx context.Context, userID int, orderNum string) (order.Hold, error) {
+	hold, err := rs.storage.CaptureHold(ctx, userID, orderNum)
+	if rs.checkRetry(err) {
+		err = rs.retry(func() error {
+			hold, err = rs.storage.CaptureHold(ctx, userID, orderNum)
+			if err != nil {
+				return fmt.Errorf("failed retry capture hold: %w", err)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return order.Hold{}, fmt.Errorf("failed capture hold: %w", err)
+	}
+	return hold, nil
+}
+
+func (rs *RetryStorage) ReleaseHold(ctx context.Context, userID int, orderNum string) (order.Hold, error) {
+	hold, err := rs.storage.ReleaseHold(ctx, userID, orderNum)
+	if rs.checkRetry(err) {
+		err = rs.retry(func() error {
+			hold, err = rs.storage.ReleaseHold(ctx, userID, orderNum)
+			if err != nil {
+				return fmt.Errorf("failed retry release hold: %w", err)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return order.Hold{}, fmt.Errorf("failed release hold: %w", err)
+	}
+	return hold, nil
+}
+
+func (rs *RetryStorage) ReleaseExpiredHolds(ctx context.Context) (int, error) {
+	released, err := rs.storage.ReleaseExpiredHolds(ctx)
+	if rs.checkRetry(err) {
+		err = rs.retry(func() error {
+			released, err = rs.storage.ReleaseExpiredHolds(ctx)
+			if err != nil {
+				return fmt.Errorf("failed retry release expired holds: %w", err)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed release expired holds: %w", err)
+	}
+	return released, nil
+}
+
+func (rs *RetryStorage) ReserveIdempotencyKey(
+	ctx context.Context,
+	key idempotency.Key,
+	ttl time.Duration,
+) (idempotency.Record, bool, error) {
+	record, reserved, err := rs.storage.ReserveIdempotencyKey(ctx, key, ttl)
+	if rs.checkRetry(err) {
+		err = rs.retry(func() error {
+			record, reserved, err = rs.storage.ReserveIdempotencyKey(ctx, key, ttl)
+			if err != nil {
+				return fmt.Errorf("failed retry reserve idempotency key: %w", err)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return idempotency.Record{}, false, fmt.Errorf("failed reserve idempotency key: %w", err)
+	}
+	return record, reserved, nil
+}
+
+func (rs *RetryStorage) SaveIdempotentResponse(
+	ctx context.Context,
+	key idempotency.Key,
+	resp idempotency.Response,
+) error {
+	err := rs.storage.SaveIdempotentResponse(ctx, key, resp)
+	if rs.checkRetry(err) {
+		err = rs.retry(func() error {
+			err = rs.storage.SaveIdempotentResponse(ctx, key, resp)
+			if err != nil {
+				return fmt.Errorf("failed retry save idempotent response: %w", err)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed save idempotent response: %w", err)
+	}
+	return nil
+}
+
+func (rs *RetryStorage) ReleaseIdempotencyKey(ctx context.Context, key idempotency.Key) error {
+	err := rs.storage.ReleaseIdempotencyKey(ctx, key)
+	if rs.checkRetry(err) {
+		err = rs.retry(func() error {
+			err = rs.storage.ReleaseIdempotencyKey(ctx, key)
+			if err != nil {
+				return fmt.Errorf("failed retry release idempotency key: %w", err)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed release idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (rs *RetryStorage) PurgeIdempotencyKeys(ctx context.Context) (int64, error) {
+	purged, err := rs.storage.PurgeIdempotencyKeys(ctx)
+	if rs.checkRetry(err) {
+		err = rs.retry(func() error {
+			purged, err = rs.storage.PurgeIdempotencyKeys(ctx)
+			if err != nil {
+				return fmt.Errorf("failed retry purge idempotency keys: %w", err)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed purge idempotency keys: %w", err)
+	}
+	return purged, nil
+}
+
+// RecordWebhookDelivery is not retried: a retry after a lost commit
+// would report the callback as already delivered.
+func (rs *RetryStorage) RecordWebhookDelivery(ctx context.Context, signature string, ttl time.Duration) (bool, error) {
+	recorded, err := rs.storage.RecordWebhookDelivery(ctx, signature, ttl)
+	if err != nil {
+		return false, fmt.Errorf("failed record webhook delivery: %w", err)
+	}
+	return recorded, nil
+}
+
+func (rs *RetryStorage) PurgeWebhookDeliveries(ctx context.Context) (int64, error) {
+	purged, err := rs.storage.PurgeWebhookDeliveries(ctx)
+	if rs.checkRetry(err) {
+		err = rs.retry(func() error {
+			purged, err = rs.storage.PurgeWebhookDeliveries(ctx)
+			if err != nil {
+				return fmt.Errorf("failed retry purge webhook deliveries: %w", err)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed purge webhook deliveries: %w", err)
+	}
+	return purged, nil
+}
@@ -0,0 +1,125 @@
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half-open"
+)
+
+var ErrOpen = errors.New("circuit breaker is open")
+
+// OpenError is returned while the circuit is open.
+// Until is the moment when a probe request will be allowed.
+type OpenError struct {
+	Until time.Time
+}
+
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("%v until %s", ErrOpen, e.Until.Format(time.RFC3339))
+}
+
+func (e *OpenError) Unwrap() error {
+	return ErrOpen
+}
+
+// Breaker opens after threshold failures in a row. After cooldown it lets
+// a single probe through: success closes the circuit, failure opens it again.
+type Breaker struct {
+	openedAt      time.Time
+	onStateChange func(from, to string)
+	state         string
+	cooldown      time.Duration
+	threshold     int
+	failures      int
+	probing       bool
+	mu            sync.Mutex
+}
+
+func New(threshold int, cooldown time.Duration, onStateChange func(from, to string)) *Breaker {
+	return &Breaker{
+		state:         StateClosed,
+		threshold:     threshold,
+		cooldown:      cooldown,
+		onStateChange: onStateChange,
+	}
+}
+
+// Allow reports whether a request may be sent now.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		until := b.openedAt.Add(b.cooldown)
+		if time.Now().Before(until) {
+			return &OpenError{Until: until}
+		}
+		b.setState(StateHalfOpen)
+		b.probing = true
+		return nil
+	case StateHalfOpen:
+		if b.probing {
+			return &OpenError{Until: time.Now().Add(b.cooldown)}
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.probing = false
+	b.setState(StateClosed)
+}
+
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+	if b.state == StateHalfOpen || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		b.setState(StateOpen)
+	}
+}
+
+// Cancel is called when the caller gave up on the request, so its result
+// says nothing about accrual. A pending probe may be retried.
+func (b *Breaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+func (b *Breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+func (b *Breaker) setState(state string) {
+	if b.state == state {
+		return
+	}
+	from := b.state
+	b.state = state
+	if b.onStateChange != nil {
+		b.onStateChange(from, state)
+	}
+}
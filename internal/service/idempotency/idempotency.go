@@ -0,0 +1,50 @@
+package idempotency
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+// Key identifies a request by the Idempotency-Key header value within
+// the endpoint and the user. UserID is zero for anonymous requests, their
+// keys are scoped by RequestHash, so that different clients using the same
+// key do not share responses. RequestHash tells a retry from a different
+// request with the same key.
+type Key struct {
+	Path        string
+	Value       string
+	Scope       string
+	RequestHash string
+	UserID      int
+}
+
+// Response is the first response to the key, replayed on retries.
+// Subject is the user the response authenticated, a replay issues
+// a new token for the user instead of the stored one.
+type Response struct {
+	Header     http.Header
+	Body       []byte
+	StatusCode int
+	Subject    int
+}
+
+// Record is the stored state of the key. Response is nil while
+// the first request is still being handled.
+type Record struct {
+	Response    *Response
+	RequestHash string
+}
+
+// Hash returns the fingerprint of the request. It is keyed with the server
+// secret, so that the stored value does not reveal the body.
+func Hash(secret []byte, method, path string, body []byte) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,120 @@
+package ledger
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/money"
+)
+
+const (
+	KindAccrual    = "accrual"
+	KindWithdrawal = "withdrawal"
+	KindAdjustment = "adjustment"
+	KindRefund     = "refund"
+	KindExpiry     = "expiry"
+)
+
+// System accounts are the other side of every posting to a user account.
+const (
+	AccountAccrual     = "system:accrual"
+	AccountWithdrawals = "system:withdrawals"
+	AccountAdjustments = "system:adjustments"
+	AccountExpired     = "system:expired"
+)
+
+var (
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrRefundExceeded    = errors.New("refund exceeds the withdrawn sum")
+)
+
+// Posting moves Amount from Debit account to Credit account. Postings are
+// never changed or deleted, a mistake is fixed by a new posting.
+// The balance of a user account is its credits minus its debits.
+type Posting struct {
+	CreatedAt time.Time    `json:"created_at"`
+	Kind      string       `json:"kind"`
+	Debit     string       `json:"debit"`
+	Credit    string       `json:"credit"`
+	OrderNum  string       `json:"order,omitempty"`
+	Comment   string       `json:"comment,omitempty"`
+	Amount    money.Amount `json:"amount"`
+	ID        int64        `json:"id"`
+}
+
+// UserAccount is the ledger account of the row in accounts table.
+func UserAccount(accountID int) string {
+	return "user:" + strconv.Itoa(accountID)
+}
+
+func Accrual(accountID int, orderNum string, amount money.Amount) Posting {
+	return Posting{
+		Kind:     KindAccrual,
+		Debit:    AccountAccrual,
+		Credit:   UserAccount(accountID),
+		OrderNum: orderNum,
+		Amount:   amount,
+	}
+}
+
+func Withdrawal(accountID int, orderNum string, amount money.Amount) Posting {
+	return Posting{
+		Kind:     KindWithdrawal,
+		Debit:    UserAccount(accountID),
+		Credit:   AccountWithdrawals,
+		OrderNum: orderNum,
+		Amount:   amount,
+	}
+}
+
+// Refund returns a part of the withdrawal back to the user account.
+func Refund(accountID int, orderNum string, amount money.Amount, comment string) Posting {
+	return Posting{
+		Kind:     KindRefund,
+		Debit:    AccountWithdrawals,
+		Credit:   UserAccount(accountID),
+		OrderNum: orderNum,
+		Comment:  comment,
+		Amount:   amount,
+	}
+}
+
+// Expiry writes off the expired points of the lot credited for the order.
+func Expiry(accountID int, orderNum string, amount money.Amount) Posting {
+	return Posting{
+		Kind:     KindExpiry,
+		Debit:    UserAccount(accountID),
+		Credit:   AccountExpired,
+		OrderNum: orderNum,
+		Amount:   amount,
+	}
+}
+
+// Adjustment credits the user account with a positive amount
+// and debits it with a negative one.
+func Adjustment(accountID int, amount money.Amount, comment string) Posting {
+	posting := Posting{
+		Kind:    KindAdjustment,
+		Debit:   AccountAdjustments,
+		Credit:  UserAccount(accountID),
+		Comment: comment,
+		Amount:  amount,
+	}
+	if amount < 0 {
+		posting.Debit, posting.Credit = posting.Credit, posting.Debit
+		posting.Amount = -amount
+	}
+	return posting
+}
+
+// Discrepancy is an account whose cached totals in accounts table
+// differ from the totals derived from the ledger.
+type Discrepancy struct {
+	AccountID       int          `json:"account_id"`
+	UserID          int          `json:"user_id"`
+	CachedBalance   money.Amount `json:"cached_balance"`
+	LedgerBalance   money.Amount `json:"ledger_balance"`
+	CachedWithdrawn money.Amount `json:"cached_withdrawn"`
+	LedgerWithdrawn money.Amount `json:"ledger_withdrawn"`
+}
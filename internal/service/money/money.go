@@ -0,0 +1,134 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	scale     = 2
+	centsBase = 100
+)
+
+var ErrNullAmount = errors.New("amount is NULL")
+
+// Amount is a sum of loyalty points kept in hundredths of a point.
+// It is stored as NUMERIC(20,2) and marshals to a plain JSON number,
+// so 729.98 points are encoded exactly like the float64 value was.
+type Amount int64
+
+// FromCents returns the amount of n hundredths of a point.
+func FromCents(n int64) Amount {
+	return Amount(n)
+}
+
+func (a Amount) Cents() int64 {
+	return int64(a)
+}
+
+// String formats the amount as a decimal without trailing zeros.
+func (a Amount) String() string {
+	sign := ""
+	cents := int64(a)
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+
+	whole := strconv.FormatInt(cents/centsBase, 10)
+	frac := cents % centsBase
+	switch {
+	case frac == 0:
+		return sign + whole
+	case frac%10 == 0:
+		return fmt.Sprintf("%s%s.%d", sign, whole, frac/10)
+	default:
+		return fmt.Sprintf("%s%s.%02d", sign, whole, frac)
+	}
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON parses a JSON number exactly. Digits beyond hundredths
+// are rounded half away from zero.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	rat, ok := new(big.Rat).SetString(string(data))
+	if !ok {
+		return &json.UnmarshalTypeError{Value: string(data), Type: reflect.TypeOf(*a)}
+	}
+
+	amount, err := fromRat(rat)
+	if err != nil {
+		return &json.UnmarshalTypeError{Value: string(data), Type: reflect.TypeOf(*a)}
+	}
+	*a = amount
+	return nil
+}
+
+// NumericValue lets pgx encode the amount as NUMERIC.
+func (a Amount) NumericValue() (pgtype.Numeric, error) {
+	return pgtype.Numeric{
+		Int:   big.NewInt(int64(a)),
+		Exp:   -scale,
+		Valid: true,
+	}, nil
+}
+
+// ScanNumeric lets pgx decode NUMERIC into the amount.
+func (a *Amount) ScanNumeric(n pgtype.Numeric) error {
+	if !n.Valid {
+		return ErrNullAmount
+	}
+	if n.NaN || n.InfinityModifier != pgtype.Finite {
+		return fmt.Errorf("invalid amount %v", n)
+	}
+
+	rat := new(big.Rat).SetInt(n.Int)
+	exp := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(n.Exp))), nil)
+	if n.Exp >= 0 {
+		rat.Mul(rat, new(big.Rat).SetInt(exp))
+	} else {
+		rat.Quo(rat, new(big.Rat).SetInt(exp))
+	}
+
+	amount, err := fromRat(rat)
+	if err != nil {
+		return err
+	}
+	*a = amount
+	return nil
+}
+
+func fromRat(rat *big.Rat) (Amount, error) {
+	rat = new(big.Rat).Mul(rat, big.NewRat(centsBase, 1))
+
+	quo, rem := new(big.Int).QuoRem(rat.Num(), rat.Denom(), new(big.Int))
+	// round half away from zero: |rem| * 2 >= denom
+	if rem.Sign() != 0 && new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(rat.Denom()) >= 0 {
+		quo.Add(quo, big.NewInt(int64(rem.Sign())))
+	}
+
+	if !quo.IsInt64() {
+		return 0, fmt.Errorf("amount %s is out of range", rat.FloatString(scale))
+	}
+	return Amount(quo.Int64()), nil
+}
+
+func abs(n int32) int32 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,195 @@
+package money
+
+import (
+	"encoding/json"
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func TestUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    Amount
+		wantErr bool
+	}{
+		{name: "integer", data: "751", want: 75100},
+		{name: "hundredths", data: "729.98", want: 72998},
+		{name: "exponent", data: "1.5e2", want: 15000},
+		{name: "half cent rounds up", data: "0.005", want: 1},
+		{name: "below half cent rounds down", data: "1.004", want: 100},
+		{name: "above half cent rounds up", data: "1.0051", want: 101},
+		{name: "negative", data: "-12.3", want: -1230},
+		{name: "negative half cent rounds away from zero", data: "-0.005", want: -1},
+		{name: "negative below half cent", data: "-1.004", want: -100},
+		{name: "max", data: "92233720368547758.07", want: math.MaxInt64},
+		{name: "min", data: "-92233720368547758.08", want: math.MinInt64},
+		{name: "overflow", data: "92233720368547758.08", wantErr: true},
+		{name: "overflow by rounding", data: "92233720368547758.075", wantErr: true},
+		{name: "negative overflow", data: "-92233720368547758.09", wantErr: true},
+		{name: "huge", data: "1e40", wantErr: true},
+		{name: "string", data: `"10"`, wantErr: true},
+		{name: "garbage", data: "ten", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Amount
+			err := json.Unmarshal([]byte(tt.data), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unmarshal %s: err = %v, want error %v", tt.data, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("unmarshal %s = %d cents, want %d", tt.data, got.Cents(), tt.want.Cents())
+			}
+		})
+	}
+}
+
+func TestUnmarshalJSONNullKeepsAmount(t *testing.T) {
+	got := FromCents(100)
+	if err := json.Unmarshal([]byte("null"), &got); err != nil {
+		t.Fatalf("unmarshal null: %v", err)
+	}
+	if got != FromCents(100) {
+		t.Errorf("amount = %s, want 1", got)
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		want   string
+		amount Amount
+	}{
+		{amount: 0, want: "0"},
+		{amount: 75100, want: "751"},
+		{amount: 72998, want: "729.98"},
+		{amount: 150, want: "1.5"},
+		{amount: 5, want: "0.05"},
+		{amount: -5, want: "-0.05"},
+		{amount: -1230, want: "-12.3"},
+		{amount: -100, want: "-1"},
+		{amount: math.MaxInt64, want: "92233720368547758.07"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := tt.amount.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	amounts := []Amount{0, 1, 5, 10, 99, 100, 72998, -1, -72998, math.MaxInt64, math.MinInt64 + 1}
+
+	for _, amount := range amounts {
+		data, err := json.Marshal(struct {
+			Sum Amount `json:"sum"`
+		}{Sum: amount})
+		if err != nil {
+			t.Fatalf("marshal %d cents: %v", amount.Cents(), err)
+		}
+
+		var got struct {
+			Sum Amount `json:"sum"`
+		}
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("unmarshal %s: %v", data, err)
+		}
+		if got.Sum != amount {
+			t.Errorf("round trip of %d cents through %s = %d cents", amount.Cents(), data, got.Sum.Cents())
+		}
+	}
+}
+
+func TestNumericRoundTrip(t *testing.T) {
+	amounts := []Amount{0, 1, -1, 72998, -72998, math.MaxInt64, math.MinInt64}
+
+	for _, amount := range amounts {
+		n, err := amount.NumericValue()
+		if err != nil {
+			t.Fatalf("numeric value of %d cents: %v", amount.Cents(), err)
+		}
+
+		var got Amount
+		if err := got.ScanNumeric(n); err != nil {
+			t.Fatalf("scan %d cents: %v", amount.Cents(), err)
+		}
+		if got != amount {
+			t.Errorf("round trip of %d cents = %d cents", amount.Cents(), got.Cents())
+		}
+	}
+}
+
+func TestScanNumeric(t *testing.T) {
+	tests := []struct {
+		name    string
+		numeric pgtype.Numeric
+		want    Amount
+		wantErr bool
+	}{
+		{
+			name:    "scale 2",
+			numeric: pgtype.Numeric{Int: big.NewInt(72998), Exp: -2, Valid: true},
+			want:    72998,
+		},
+		{
+			name:    "positive exponent",
+			numeric: pgtype.Numeric{Int: big.NewInt(75), Exp: 1, Valid: true},
+			want:    75000,
+		},
+		{
+			name:    "half cent rounds up",
+			numeric: pgtype.Numeric{Int: big.NewInt(5), Exp: -3, Valid: true},
+			want:    1,
+		},
+		{
+			name:    "below half cent rounds down",
+			numeric: pgtype.Numeric{Int: big.NewInt(1004), Exp: -3, Valid: true},
+			want:    100,
+		},
+		{
+			name:    "negative half cent rounds away from zero",
+			numeric: pgtype.Numeric{Int: big.NewInt(-5), Exp: -3, Valid: true},
+			want:    -1,
+		},
+		{
+			name:    "overflow",
+			numeric: pgtype.Numeric{Int: big.NewInt(1), Exp: 20, Valid: true},
+			wantErr: true,
+		},
+		{
+			name:    "null",
+			numeric: pgtype.Numeric{},
+			wantErr: true,
+		},
+		{
+			name:    "NaN",
+			numeric: pgtype.Numeric{NaN: true, Valid: true},
+			wantErr: true,
+		},
+		{
+			name:    "infinity",
+			numeric: pgtype.Numeric{InfinityModifier: pgtype.Infinity, Valid: true},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Amount
+			err := got.ScanNumeric(tt.numeric)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("scan = %d cents, want %d", got.Cents(), tt.want.Cents())
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package order
+
+import (
+	"errors"
+	"time"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/money"
+)
+
+const (
+	HoldStatusHeld     = "HELD"
+	HoldStatusCaptured = "CAPTURED"
+	HoldStatusReleased = "RELEASED"
+)
+
+var (
+	ErrOrderExists   = errors.New("order number is already used")
+	ErrHoldNotActive = errors.New("hold is already captured or released")
+	ErrHoldExpired   = errors.New("hold has expired")
+)
+
+// Hold reserves Sum of the user balance for the order until the payment
+// clears. Held points are not available for withdrawals but are not
+// withdrawn until the hold is captured. A hold that is neither captured
+// nor released before ExpiresAt is released automatically. Merchant is
+// the merchant of the order, the only one that may refund the withdrawal.
+type Hold struct {
+	CreatedAt time.Time    `json:"created_at"`
+	ExpiresAt time.Time    `json:"expires_at"`
+	Number    string       `json:"order"`
+	Merchant  string       `json:"merchant,omitempty"`
+	Status    string       `json:"status"`
+	Sum       money.Amount `json:"sum"`
+	UserID    int          `json:"-"`
+}
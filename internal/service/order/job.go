@@ -0,0 +1,23 @@
+package order
+
+import "time"
+
+// AccrualJob is a pending accrual lookup for an order.
+// Attempts counts failed lookups in a row, LastError keeps the reason
+// of the last failure. A job with non-nil DeadAt is dead-lettered and
+// is not processed until an admin requeues it. A job with non-nil
+// QuarantinedAt got an invalid answer from accrual and waits for an admin too.
+type AccrualJob struct {
+	CreatedAt     time.Time  `json:"created_at"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	DeadAt        *time.Time `json:"dead_at,omitempty"`
+	QuarantinedAt *time.Time `json:"quarantined_at,omitempty"`
+	LastError     string     `json:"last_error"`
+	Order         Order      `json:"order"`
+	Attempts      int        `json:"attempts"`
+}
+
+// Parked reports whether the job waits for an admin decision.
+func (job AccrualJob) Parked() bool {
+	return job.DeadAt != nil || job.QuarantinedAt != nil
+}
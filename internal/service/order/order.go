@@ -1,6 +1,10 @@
 package order
 
-import "time"
+import (
+	"time"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/money"
+)
 
 const (
 	StatusNew        = "NEW"
@@ -9,16 +13,42 @@ const (
 	StatusProcessed  = "PROCESSED"
 )
 
+// Order is a purchase uploaded by a user. Provider is the name of
+// the accrual system that calculated the order.
 type Order struct {
-	UploadTime time.Time `json:"uploaded_at"`
-	Status     string    `json:"status"`
-	Number     string    `json:"number"`
-	Accrual    float64   `json:"accrual,omitempty"`
-	UserID     int       `json:"-"`
+	UploadTime time.Time    `json:"uploaded_at"`
+	Status     string       `json:"status"`
+	Number     string       `json:"number"`
+	Provider   string       `json:"provider,omitempty"`
+	Accrual    money.Amount `json:"accrual,omitempty"`
+	UserID     int          `json:"-"`
 }
 
+const (
+	WithdrawStateWithdrawn         = "WITHDRAWN"
+	WithdrawStatePartiallyRefunded = "PARTIALLY_REFUNDED"
+	WithdrawStateRefunded          = "REFUNDED"
+)
+
+// Withdraw is a debit of the user account for an order. Refunded is
+// the part of Sum returned to the account by refunds.
 type Withdraw struct {
-	Timestamp time.Time `json:"processed_at,omitempty"`
-	Number    string    `json:"order"`
-	Sum       float64   `json:"sum"`
+	Timestamp time.Time    `json:"processed_at,omitempty"`
+	Number    string       `json:"order"`
+	Merchant  string       `json:"merchant,omitempty"`
+	State     string       `json:"state,omitempty"`
+	Sum       money.Amount `json:"sum"`
+	Refunded  money.Amount `json:"refunded,omitempty"`
+}
+
+// WithdrawState tells how much of the withdrawal has been refunded.
+func WithdrawState(sum, refunded money.Amount) string {
+	switch {
+	case refunded <= 0:
+		return WithdrawStateWithdrawn
+	case refunded < sum:
+		return WithdrawStatePartiallyRefunded
+	default:
+		return WithdrawStateRefunded
+	}
 }
@@ -0,0 +1,78 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	AccrualStatusRegistered = "REGISTERED"
+	AccrualStatusProcessing = "PROCESSING"
+	AccrualStatusInvalid    = "INVALID"
+	AccrualStatusProcessed  = "PROCESSED"
+)
+
+var (
+	ErrInvalidTransition    = errors.New("invalid order status transition")
+	ErrUnknownAccrualStatus = errors.New("unknown accrual order status")
+)
+
+// transitions lists the statuses each status can move to.
+// Final statuses have no outgoing transitions.
+var transitions = map[string][]string{
+	StatusNew:        {StatusProcessing, StatusInvalid, StatusProcessed},
+	StatusProcessing: {StatusInvalid, StatusProcessed},
+	StatusInvalid:    {},
+	StatusProcessed:  {},
+}
+
+var accrualStatuses = map[string]string{
+	AccrualStatusRegistered: StatusNew,
+	AccrualStatusProcessing: StatusProcessing,
+	AccrualStatusInvalid:    StatusInvalid,
+	AccrualStatusProcessed:  StatusProcessed,
+}
+
+type Transition struct {
+	At     time.Time
+	Number string
+	From   string
+	To     string
+}
+
+func CanTransition(from, to string) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+func IsFinal(status string) bool {
+	next, ok := transitions[status]
+	return ok && len(next) == 0
+}
+
+// FromAccrualStatus maps an order status reported by accrual
+// to the status of the order in gophermart.
+func FromAccrualStatus(accrualStatus string) (string, error) {
+	status, ok := accrualStatuses[accrualStatus]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownAccrualStatus, accrualStatus)
+	}
+	return status, nil
+}
+
+func NewTransition(number, from, to string) (Transition, error) {
+	if !CanTransition(from, to) {
+		return Transition{}, fmt.Errorf("%w: order %s from %s to %s", ErrInvalidTransition, number, from, to)
+	}
+	return Transition{
+		At:     time.Now(),
+		Number: number,
+		From:   from,
+		To:     to,
+	}, nil
+}
@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket with a burst of one request that is shared by
+// every worker talking to one accrual provider. Besides the steady rate it can
+// be paused until a deadline, which blocks all waiters at once.
+type Limiter struct {
+	next       time.Time
+	pauseUntil time.Time
+	interval   time.Duration
+	mu         sync.Mutex
+}
+
+func New(requestsPerMinute int) *Limiter {
+	l := &Limiter{}
+	l.SetRate(requestsPerMinute)
+	return l
+}
+
+// SetRate changes the steady rate. Zero or negative value disables the limit.
+func (l *Limiter) SetRate(requestsPerMinute int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if requestsPerMinute <= 0 {
+		l.interval = 0
+		return
+	}
+	l.interval = time.Minute / time.Duration(requestsPerMinute)
+}
+
+// Pause blocks all waiters until the given deadline. An earlier deadline
+// never shortens the current pause.
+func (l *Limiter) Pause(until time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until.After(l.pauseUntil) {
+		l.pauseUntil = until
+	}
+}
+
+// PausedUntil returns the current pause deadline.
+func (l *Limiter) PausedUntil() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.pauseUntil
+}
+
+// Wait blocks until the caller is allowed to send a request or ctx is done.
+func (l *Limiter) Wait(ctx context.Context) error {
+	for {
+		slot := l.reserve(time.Now())
+
+		if err := sleepUntil(ctx, slot); err != nil {
+			return err
+		}
+
+		if !l.paused(slot) {
+			return nil
+		}
+	}
+}
+
+func (l *Limiter) reserve(now time.Time) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	slot := now
+	if l.next.After(slot) {
+		slot = l.next
+	}
+	if l.pauseUntil.After(slot) {
+		slot = l.pauseUntil
+	}
+	l.next = slot.Add(l.interval)
+
+	return slot
+}
+
+func (l *Limiter) paused(slot time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.pauseUntil.After(slot)
+}
+
+func sleepUntil(ctx context.Context, t time.Time) error {
+	delay := time.Until(t)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("rate limiter wait canceled: %w", ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
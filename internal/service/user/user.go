@@ -6,8 +6,11 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"time"
 
 	"golang.org/x/crypto/argon2"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/money"
 )
 
 const (
@@ -27,11 +30,19 @@ type User struct {
 	Password string `json:"password"`
 }
 
+// Accaunt is the balance of the user. Held is the part of Balance
+// reserved by holds, Available is the rest of it. ExpiringSoon is
+// the part of Balance that expires within the notice period, ExpiringAt
+// is the date the first of these points expire.
 type Accaunt struct {
-	ID        int     `json:"-"`
-	UserID    int     `json:"-"`
-	Balance   float64 `json:"current"`
-	Withdrawn float64 `json:"withdrawn"`
+	ExpiringAt   *time.Time   `json:"expiring_at,omitempty"`
+	ID           int          `json:"-"`
+	UserID       int          `json:"-"`
+	Balance      money.Amount `json:"current"`
+	Available    money.Amount `json:"available"`
+	Held         money.Amount `json:"held"`
+	Withdrawn    money.Amount `json:"withdrawn"`
+	ExpiringSoon money.Amount `json:"expiring_soon,omitempty"`
 }
 
 func (u User) CheckPassword(hashPasswordDB string) error {
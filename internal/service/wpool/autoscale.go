@@ -0,0 +1,102 @@
+package wpool
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	growDivider = 4
+)
+
+// accrualStats collects accrual responses between two autoscaling ticks.
+type accrualStats struct {
+	latency   time.Duration
+	requests  int
+	throttled int
+	mu        sync.Mutex
+}
+
+func (s *accrualStats) observe(latency time.Duration, throttled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests++
+	s.latency += latency
+	if throttled {
+		s.throttled++
+	}
+}
+
+// reset returns the average latency and the number of 429 answers
+// since the previous call.
+func (s *accrualStats) reset() (time.Duration, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var avg time.Duration
+	if s.requests > 0 {
+		avg = s.latency / time.Duration(s.requests)
+	}
+	throttled := s.throttled
+
+	s.requests = 0
+	s.latency = 0
+	s.throttled = 0
+
+	return avg, throttled
+}
+
+// autoscale adjusts the number of workers within the configured bounds:
+// it halves the pool when accrual answers 429, shrinks it by one when
+// latency exceeds the threshold or the queue is empty, and grows it
+// when the queue holds more jobs than there are workers.
+func (pool *WorkerPool) autoscale(ctx context.Context) {
+	log := pool.logger.LogrusLog
+
+	ticker := time.NewTicker(pool.cfg.ScaleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		latency, throttled := pool.stats.reset()
+		current := pool.Workers()
+		target := pool.targetWorkers(current, len(pool.queue), latency, throttled)
+		if target == current {
+			continue
+		}
+
+		log.Infof("scale pool of workers from %d to %d: queue %d, latency %v, throttled %d",
+			current, target, len(pool.queue), latency, throttled)
+
+		for range target - current {
+			pool.addWorker(ctx)
+		}
+		for range current - target {
+			pool.removeWorker()
+		}
+	}
+}
+
+func (pool *WorkerPool) targetWorkers(current, queued int, latency time.Duration, throttled int) int {
+	target := current
+
+	switch {
+	case throttled > 0:
+		target = current / 2
+	case latency > pool.cfg.LatencyThreshold:
+		target = current - 1
+	case queued > current:
+		target = current + max(current/growDivider, 1)
+	case queued == 0:
+		target = current - 1
+	}
+
+	return min(max(target, pool.cfg.MinWorkers), pool.cfg.MaxWorkers)
+}
@@ -0,0 +1,79 @@
+package wpool
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/config"
+)
+
+var ErrQueueFull = errors.New("accrual queue is full")
+
+// QueueFullError is returned by Admit when the queue is full and
+// the overflow policy is reject.
+type QueueFullError struct {
+	RetryAfter time.Duration
+}
+
+func (e *QueueFullError) Error() string {
+	return fmt.Sprintf("%v, retry after %s", ErrQueueFull, e.RetryAfter)
+}
+
+func (e *QueueFullError) Unwrap() error {
+	return ErrQueueFull
+}
+
+type QueueStats struct {
+	Policy    string `json:"policy"`
+	Length    int    `json:"length"`
+	Capacity  int    `json:"capacity"`
+	InFlight  int    `json:"in_flight"`
+	Spilled   uint64 `json:"spilled"`
+	Rejected  uint64 `json:"rejected"`
+	Saturated bool   `json:"saturated"`
+}
+
+type overflowStats struct {
+	spilled  atomic.Uint64
+	rejected atomic.Uint64
+}
+
+// Saturated reports whether workers are behind by a whole queue.
+func (pool *WorkerPool) Saturated() bool {
+	return len(pool.queue) == cap(pool.queue)
+}
+
+// Admit decides whether a new order may be accepted. Accepted orders are
+// always stored in the database, so with the spill policy a full queue only
+// means that the order waits there until the dispatcher has room for it.
+func (pool *WorkerPool) Admit() error {
+	if !pool.Saturated() {
+		return nil
+	}
+
+	if pool.cfg.OverflowPolicy == config.OverflowReject {
+		pool.overflow.rejected.Add(1)
+		return &QueueFullError{RetryAfter: pool.cfg.OverflowRetry}
+	}
+
+	pool.overflow.spilled.Add(1)
+	return nil
+}
+
+func (pool *WorkerPool) QueueStats() QueueStats {
+	pool.inFlightMu.Lock()
+	inFlight := len(pool.inFlight)
+	pool.inFlightMu.Unlock()
+
+	return QueueStats{
+		Policy:    pool.cfg.OverflowPolicy,
+		Length:    len(pool.queue),
+		Capacity:  cap(pool.queue),
+		InFlight:  inFlight,
+		Spilled:   pool.overflow.spilled.Load(),
+		Rejected:  pool.overflow.rejected.Load(),
+		Saturated: pool.Saturated(),
+	}
+}
@@ -0,0 +1,38 @@
+package wpool
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	MinRetryDelay   = time.Second
+	MaxRetryDelay   = 10 * time.Minute
+	MinPollInterval = time.Second
+	MaxPollInterval = 2 * time.Minute
+	pollAgeDivider  = 10
+	maxBackoffShift = 30
+)
+
+// retryDelay returns exponential backoff with equal jitter:
+// the delay is in [d/2, d) where d = MinRetryDelay * 2^(attempts-1).
+func retryDelay(attempts int) time.Duration {
+	shift := min(max(attempts-1, 0), maxBackoffShift)
+	delay := min(MinRetryDelay<<shift, MaxRetryDelay)
+	return withJitter(delay)
+}
+
+// pollInterval grows with the time the order has been pending, so fresh
+// orders are checked often and long running ones do not waste requests.
+func pollInterval(pending time.Duration) time.Duration {
+	interval := min(max(pending/pollAgeDivider, MinPollInterval), MaxPollInterval)
+	return withJitter(interval)
+}
+
+func withJitter(d time.Duration) time.Duration {
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+	return half + rand.N(half)
+}
@@ -2,89 +2,430 @@ package wpool
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"time"
 
+	"github.com/zhenyanesterkova/gmloyalty/internal/config"
 	"github.com/zhenyanesterkova/gmloyalty/internal/myclient"
 	"github.com/zhenyanesterkova/gmloyalty/internal/repository"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/breaker"
 	"github.com/zhenyanesterkova/gmloyalty/internal/service/logger"
 	"github.com/zhenyanesterkova/gmloyalty/internal/service/order"
 )
 
 const (
-	StatusNewAccrual        = "REGISTERED"
-	StatusProcessingAccrual = "PROCESSING"
-	StatusInvalidAccrual    = "INVALID"
-	StatusProcessedAccrual  = "PROCESSED"
-	SizeQueue               = 1024
+	PollInterval = time.Second
 )
 
 type WorkerPool struct {
-	repo         repository.Store
-	Queue        chan order.Order
-	TimeOut      chan time.Duration
-	logger       logger.LogrusLogger
-	accrual      *myclient.AccrualStruct
-	errorCh      chan error
-	wg           sync.WaitGroup
-	countWorkers int
+	repo       repository.Store
+	instanceID string
+	lease      time.Duration
+	queue      chan order.AccrualJob
+	wakeUp     chan struct{}
+	logger     logger.LogrusLogger
+	accrual    myclient.AccrualClient
+	stats      *accrualStats
+	overflow   *overflowStats
+	workers    []chan struct{}
+	inFlight   map[string]struct{}
+	cfg        config.PoolConfig
+	wg         sync.WaitGroup
+	workersMu  sync.Mutex
+	inFlightMu sync.Mutex
 }
 
 func New(
 	repo repository.Store,
 	logger logger.LogrusLogger,
-	accrual *myclient.AccrualStruct,
-	countWorkersInPool int,
+	accrual myclient.AccrualClient,
+	cfg config.PoolConfig,
 ) *WorkerPool {
 	return &WorkerPool{
-		Queue:        make(chan order.Order, SizeQueue),
-		countWorkers: countWorkersInPool,
-		wg:           sync.WaitGroup{},
-		logger:       logger,
-		accrual:      accrual,
-		repo:         repo,
-		errorCh:      make(chan error),
+		instanceID: cfg.InstanceID,
+		lease:      cfg.LeaseDuration,
+		queue:      make(chan order.AccrualJob, cfg.QueueSize),
+		wakeUp:     make(chan struct{}, 1),
+		cfg:        cfg,
+		wg:         sync.WaitGroup{},
+		logger:     logger,
+		accrual:    accrual,
+		stats:      &accrualStats{},
+		overflow:   &overflowStats{},
+		inFlight:   map[string]struct{}{},
+		repo:       repo,
 	}
 }
 
-func (pool *WorkerPool) worker(queue chan order.Order) {
+// worker processes jobs until ctx is canceled or stop is closed. Stop is
+// checked only between jobs, so that a worker removed by autoscaling
+// does not abort the request to accrual it is waiting for.
+func (pool *WorkerPool) worker(ctx context.Context, stop chan struct{}, queue chan order.AccrualJob) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			return
+		case job := <-queue:
+			pool.process(ctx, job)
+		}
+	}
+}
+
+// process handles one claimed job. A request interrupted by ctx releases
+// the job without counting an attempt. Once the answer is received the job
+// is completed even if ctx is canceled, so that the result is not lost.
+func (pool *WorkerPool) process(ctx context.Context, job order.AccrualJob) {
 	log := pool.logger.LogrusLog
 
-	for orderInst := range queue {
-		ordeAccrualrData, err := pool.accrual.GetOrderInfo(orderInst.Number)
-		if err != nil {
-			log.Errorf("failed get points from accrual: %v", err)
-			queue <- orderInst
-			continue
+	defer pool.done(job.Order.Number)
+
+	// the job may have waited in the queue longer than its lease,
+	// so that another instance could claim it
+	owned, err := pool.repo.RenewAccrualJobLease(ctx, pool.instanceID, job.Order.Number, pool.lease)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Errorf("failed renew lease on accrual job for order %s: %v", job.Order.Number, err)
 		}
+		pool.release(job)
+		return
+	}
+	if !owned {
+		log.Debugf("lease on order %s has been taken over, skip it", job.Order.Number)
+		return
+	}
 
-		if ordeAccrualrData.Status == StatusNewAccrual ||
-			ordeAccrualrData.Status == StatusProcessingAccrual {
-			queue <- orderInst
-			continue
+	start := time.Now()
+	ordeAccrualrData, err := pool.accrual.GetOrderInfo(ctx, job.Order.Number)
+	// a paused provider is not asked at all
+	if !errors.Is(err, myclient.ErrPaused) {
+		pool.stats.observe(time.Since(start), errors.Is(err, myclient.ErrTooManyRequests))
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			pool.release(job)
+			return
+		}
+		// accrual has not registered the order yet, keep polling it
+		if errors.Is(err, myclient.ErrNoContent) {
+			log.Debugf("order %s is not registered in accrual yet", job.Order.Number)
+			pool.poll(job)
+			return
+		}
+		if until, ok := pool.overloaded(err); ok {
+			pool.postpone(job, until)
+			return
 		}
+		if errors.Is(err, myclient.ErrInvalidResponse) {
+			pool.quarantine(job, err)
+			return
+		}
+		log.Errorf("failed get points from accrual: %v", err)
+		pool.retry(job, err)
+		return
+	}
+
+	status, err := order.FromAccrualStatus(ordeAccrualrData.Status)
+	if err != nil {
+		pool.quarantine(job, err)
+		return
+	}
+
+	if !order.IsFinal(status) {
+		pool.markProcessing(job, status)
+		return
+	}
+
+	orderInst := job.Order
+	orderInst.Accrual = ordeAccrualrData.Accrual
+	orderInst.Provider = ordeAccrualrData.Provider
+	orderInst.Status = status
 
-		orderInst.Accrual = ordeAccrualrData.Accrual
-		orderInst.Status = ordeAccrualrData.Status
+	err = pool.repo.ProcessingOrder(context.WithoutCancel(ctx), orderInst)
+	if err != nil {
+		if errors.Is(err, order.ErrInvalidTransition) {
+			pool.deadLetter(job, err)
+			return
+		}
+		log.Errorf("failed processing order: %v", err)
+		pool.retry(job, err)
+		return
+	}
+}
+
+// overloaded reports whether the provider of the job asked to slow down,
+// is paused or has its circuit open. It returns the time to retry the job,
+// other jobs are not affected.
+func (pool *WorkerPool) overloaded(err error) (time.Time, bool) {
+	log := pool.logger.LogrusLog
+
+	var errTooMany *myclient.TooManyRequestsError
+	if errors.As(err, &errTooMany) {
+		log.Warnf("accrual rate limit exceeded, postpone order: %v", err)
+		return time.Now().Add(errTooMany.RetryAfter), true
+	}
 
-		err = pool.repo.ProcessingOrder(context.TODO(), orderInst)
+	var errPaused *myclient.PausedError
+	if errors.As(err, &errPaused) {
+		return errPaused.Until, true
+	}
+
+	var errOpen *breaker.OpenError
+	if errors.As(err, &errOpen) {
+		log.Debugf("accrual circuit is open, postpone order: %v", err)
+		return errOpen.Until, true
+	}
+
+	return time.Time{}, false
+}
+
+// markProcessing moves the order to PROCESSING when accrual has started
+// calculating it and schedules the next poll.
+func (pool *WorkerPool) markProcessing(job order.AccrualJob, status string) {
+	log := pool.logger.LogrusLog
+
+	if status != job.Order.Status && order.CanTransition(job.Order.Status, status) {
+		orderInst := job.Order
+		orderInst.Status = status
+
+		err := pool.repo.UpdateOrderStatus(orderInst)
 		if err != nil {
-			log.Errorf("failed processing order: %v", err)
-			queue <- orderInst
+			log.Errorf("failed update order status: %v", err)
+			pool.retry(job, err)
+			return
+		}
+		job.Order = orderInst
+	}
+
+	pool.poll(job)
+}
+
+// retry schedules the next attempt after a failure with exponential backoff.
+// After MaxAttempts failures in a row the job is moved to dead letters.
+func (pool *WorkerPool) retry(job order.AccrualJob, cause error) {
+	log := pool.logger.LogrusLog
+
+	job.Attempts++
+	job.LastError = cause.Error()
+
+	if job.Attempts >= pool.cfg.MaxAttempts {
+		log.Errorf("order %s failed %d times", job.Order.Number, job.Attempts)
+		pool.deadLetter(job, cause)
+		return
+	}
+
+	job.NextAttemptAt = time.Now().Add(retryDelay(job.Attempts))
+	pool.release(job)
+}
+
+// quarantine parks the job after an answer that must not be credited.
+func (pool *WorkerPool) quarantine(job order.AccrualJob, cause error) {
+	log := pool.logger.LogrusLog
+
+	log.Warnf("quarantine order %s: %v", job.Order.Number, cause)
+
+	err := pool.repo.QuarantineAccrualJob(context.TODO(), job.Order.Number, cause.Error())
+	if err != nil {
+		log.Errorf("failed quarantine accrual job for order %s: %v", job.Order.Number, err)
+	}
+}
+
+// deadLetter stops processing of the job until an admin requeues it.
+func (pool *WorkerPool) deadLetter(job order.AccrualJob, cause error) {
+	log := pool.logger.LogrusLog
+
+	log.Errorf("move order %s to dead letters: %v", job.Order.Number, cause)
+
+	job.LastError = cause.Error()
+	err := pool.repo.DeadLetterAccrualJob(context.TODO(), pool.instanceID, job)
+	if err != nil {
+		log.Errorf("failed move accrual job for order %s to dead letters: %v", job.Order.Number, err)
+	}
+}
+
+// postpone returns the job to the table without counting a failed attempt.
+func (pool *WorkerPool) postpone(job order.AccrualJob, until time.Time) {
+	job.NextAttemptAt = until
+	pool.release(job)
+}
+
+// poll schedules the next check of an order that accrual is still processing.
+func (pool *WorkerPool) poll(job order.AccrualJob) {
+	job.Attempts = 0
+	job.NextAttemptAt = time.Now().Add(pollInterval(time.Since(job.CreatedAt)))
+	pool.release(job)
+}
+
+// release drops the lease on the job so that any instance
+// can claim it again once it is due.
+func (pool *WorkerPool) release(job order.AccrualJob) {
+	err := pool.repo.ReleaseAccrualJob(context.TODO(), pool.instanceID, job)
+	if err != nil {
+		pool.logger.LogrusLog.Errorf("failed release accrual job for order %s: %v", job.Order.Number, err)
+	}
+}
+
+// Notify wakes up the dispatcher without waiting for the next poll tick.
+func (pool *WorkerPool) Notify() {
+	select {
+	case pool.wakeUp <- struct{}{}:
+	default:
+	}
+}
+
+func (pool *WorkerPool) dispatch(ctx context.Context) {
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
+
+	for {
+		pool.fill(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-pool.wakeUp:
+		}
+	}
+}
+
+// fill claims as many jobs as fit in the queue. The dispatcher is the only
+// sender to the queue, so sending the claimed jobs never blocks.
+func (pool *WorkerPool) fill(ctx context.Context) {
+	log := pool.logger.LogrusLog
+
+	free := cap(pool.queue) - len(pool.queue)
+	if free == 0 {
+		log.Debugf("accrual queue is full, %d jobs are waiting in workers", len(pool.queue))
+		return
+	}
+
+	jobs, err := pool.repo.ClaimAccrualJobs(ctx, pool.instanceID, pool.lease, free)
+	if err != nil {
+		log.Errorf("failed claim accrual jobs: %v", err)
+		return
+	}
+
+	for _, job := range jobs {
+		if !pool.take(job.Order.Number) {
+			log.Debugf("order %s is already in flight, skip it", job.Order.Number)
 			continue
 		}
+		pool.queue <- job
+	}
+}
+
+// take marks the order as in flight. It returns false if the order is
+// already queued or being processed by this instance, which happens when
+// the lease expires before a worker gets to the job.
+func (pool *WorkerPool) take(orderNum string) bool {
+	pool.inFlightMu.Lock()
+	defer pool.inFlightMu.Unlock()
+
+	if _, ok := pool.inFlight[orderNum]; ok {
+		return false
 	}
+	pool.inFlight[orderNum] = struct{}{}
+	return true
 }
 
+func (pool *WorkerPool) done(orderNum string) {
+	pool.inFlightMu.Lock()
+	defer pool.inFlightMu.Unlock()
+
+	delete(pool.inFlight, orderNum)
+}
+
+// Start runs the workers and the dispatcher until ctx is canceled.
+// It returns after every worker has finished its current job and
+// the leases on jobs left in the queue have been released.
 func (pool *WorkerPool) Start(ctx context.Context) {
 	log := pool.logger.LogrusLog
 
 	log.Info("Starting pool of workers")
-	for i := 1; i <= pool.countWorkers; i++ {
-		go pool.worker(pool.Queue)
+
+	err := pool.repo.ResetAccrualJobs(ctx, pool.instanceID)
+	if err != nil {
+		log.Errorf("failed release leases left by previous run: %v", err)
+	}
+
+	workers := pool.cfg.Workers
+	if pool.cfg.Autoscale {
+		workers = min(max(workers, pool.cfg.MinWorkers), pool.cfg.MaxWorkers)
+	}
+	for range workers {
+		pool.addWorker(ctx)
+	}
+
+	if pool.cfg.Autoscale {
 		pool.wg.Add(1)
+		go func() {
+			defer pool.wg.Done()
+			pool.autoscale(ctx)
+		}()
 	}
 
+	pool.dispatch(ctx)
+
 	pool.wg.Wait()
+	pool.drain()
 	log.Info("Stoping pool of workers")
 }
+
+func (pool *WorkerPool) addWorker(ctx context.Context) {
+	stop := make(chan struct{})
+
+	pool.workersMu.Lock()
+	pool.workers = append(pool.workers, stop)
+	pool.workersMu.Unlock()
+
+	pool.wg.Add(1)
+	go func() {
+		defer pool.wg.Done()
+		pool.worker(ctx, stop, pool.queue)
+	}()
+}
+
+// removeWorker stops one worker after it finishes its current job.
+func (pool *WorkerPool) removeWorker() {
+	pool.workersMu.Lock()
+	n := len(pool.workers)
+	if n == 0 {
+		pool.workersMu.Unlock()
+		return
+	}
+	stop := pool.workers[n-1]
+	pool.workers = pool.workers[:n-1]
+	pool.workersMu.Unlock()
+
+	close(stop)
+}
+
+// Workers returns the current number of workers.
+func (pool *WorkerPool) Workers() int {
+	pool.workersMu.Lock()
+	defer pool.workersMu.Unlock()
+
+	return len(pool.workers)
+}
+
+// drain releases the jobs that were claimed but not taken by any worker.
+func (pool *WorkerPool) drain() {
+	for {
+		select {
+		case job := <-pool.queue:
+			pool.release(job)
+			pool.done(job.Order.Number)
+		default:
+			return
+		}
+	}
+}
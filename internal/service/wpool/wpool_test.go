@@ -0,0 +1,231 @@
+package wpool
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zhenyanesterkova/gmloyalty/internal/config"
+	"github.com/zhenyanesterkova/gmloyalty/internal/myclient"
+	"github.com/zhenyanesterkova/gmloyalty/internal/myclient/fakeaccrual"
+	"github.com/zhenyanesterkova/gmloyalty/internal/repository"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/logger"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/money"
+	"github.com/zhenyanesterkova/gmloyalty/internal/service/order"
+)
+
+const testOrder = "12345678903"
+
+// fakeStore records what the pool does with the jobs. Methods the pool
+// does not call are left to the embedded nil interface.
+type fakeStore struct {
+	repository.Store
+	released    []order.AccrualJob
+	processed   []order.Order
+	quarantined []string
+	dead        []order.AccrualJob
+	mu          sync.Mutex
+}
+
+func (s *fakeStore) RenewAccrualJobLease(context.Context, string, string, time.Duration) (bool, error) {
+	return true, nil
+}
+
+func (s *fakeStore) ReleaseAccrualJob(_ context.Context, _ string, job order.AccrualJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.released = append(s.released, job)
+	return nil
+}
+
+func (s *fakeStore) ProcessingOrder(_ context.Context, orderData order.Order) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.processed = append(s.processed, orderData)
+	return nil
+}
+
+func (s *fakeStore) UpdateOrderStatus(order.Order) error {
+	return nil
+}
+
+func (s *fakeStore) QuarantineAccrualJob(_ context.Context, orderNum, _ string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.quarantined = append(s.quarantined, orderNum)
+	return nil
+}
+
+func (s *fakeStore) DeadLetterAccrualJob(_ context.Context, _ string, job order.AccrualJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dead = append(s.dead, job)
+	return nil
+}
+
+func newTestPool(t *testing.T, srv *fakeaccrual.Server) (*WorkerPool, *fakeStore) {
+	t.Helper()
+
+	lg := logger.NewLogrusLogger()
+	if err := lg.SetLevelForLog("error"); err != nil {
+		t.Fatal(err)
+	}
+
+	acc, err := myclient.NewRouter(
+		config.CliConfig{
+			Address:         srv.URL,
+			RequestTimeout:  time.Second,
+			IdleConnTimeout: config.DefaultIdleConnTimeout,
+			MaxIdleConns:    config.DefaultMaxIdleConns,
+		},
+		config.BreakerConfig{
+			FailureThreshold: config.DefaultBreakerThreshold,
+			Cooldown:         config.DefaultBreakerCooldown,
+		},
+		lg,
+	)
+	if err != nil {
+		t.Fatalf("failed create accrual client: %v", err)
+	}
+
+	store := &fakeStore{}
+	pool := New(store, lg, acc, config.PoolConfig{
+		InstanceID:    "test",
+		LeaseDuration: time.Minute,
+		Workers:       1,
+		QueueSize:     1,
+		MaxAttempts:   config.DefaultMaxAttempts,
+	})
+	return pool, store
+}
+
+func newTestJob(orderNum string) order.AccrualJob {
+	return order.AccrualJob{
+		CreatedAt: time.Now(),
+		Order: order.Order{
+			Number: orderNum,
+			Status: order.StatusNew,
+			UserID: 1,
+		},
+	}
+}
+
+func TestProcessNoContentKeepsPolling(t *testing.T) {
+	srv := fakeaccrual.New()
+	defer srv.Close()
+	srv.Script(testOrder, fakeaccrual.NoContent())
+	pool, store := newTestPool(t, srv)
+
+	start := time.Now()
+	pool.process(context.Background(), newTestJob(testOrder))
+
+	if len(store.released) != 1 || len(store.processed) != 0 {
+		t.Fatalf("released %d, processed %d, want the job released only", len(store.released), len(store.processed))
+	}
+	job := store.released[0]
+	if job.Attempts != 0 {
+		t.Errorf("attempts = %d, want 0", job.Attempts)
+	}
+	if !job.NextAttemptAt.After(start) {
+		t.Errorf("next attempt at %v, want after %v", job.NextAttemptAt, start)
+	}
+}
+
+func TestProcessTooManyRequestsPostponesJobsOfProvider(t *testing.T) {
+	const otherOrder = "79927398713"
+
+	srv := fakeaccrual.New()
+	defer srv.Close()
+	srv.Script(testOrder, fakeaccrual.TooManyRequests(30*time.Second, 60))
+	srv.Script(otherOrder, fakeaccrual.Processed(money.FromCents(100)))
+	pool, store := newTestPool(t, srv)
+
+	start := time.Now()
+	pool.process(context.Background(), newTestJob(testOrder))
+	pool.process(context.Background(), newTestJob(otherOrder))
+
+	if len(store.released) != 2 || len(store.processed) != 0 {
+		t.Fatalf("released %d, processed %d, want both jobs postponed", len(store.released), len(store.processed))
+	}
+	for _, job := range store.released {
+		if job.Attempts != 0 {
+			t.Errorf("order %s: attempts = %d, want 0", job.Order.Number, job.Attempts)
+		}
+		if job.NextAttemptAt.Before(start.Add(29 * time.Second)) {
+			t.Errorf("order %s: next attempt at %v, want after Retry-After", job.Order.Number, job.NextAttemptAt)
+		}
+	}
+	if n := srv.Requests(otherOrder); n != 0 {
+		t.Errorf("paused provider got %d requests, want 0", n)
+	}
+}
+
+func TestProcessServerErrorRetries(t *testing.T) {
+	srv := fakeaccrual.New()
+	defer srv.Close()
+	srv.Script(testOrder, fakeaccrual.ServerError())
+	pool, store := newTestPool(t, srv)
+
+	start := time.Now()
+	pool.process(context.Background(), newTestJob(testOrder))
+
+	if len(store.released) != 1 || len(store.processed) != 0 {
+		t.Fatalf("released %d, processed %d, want the job released only", len(store.released), len(store.processed))
+	}
+	job := store.released[0]
+	if job.Attempts != 1 || job.LastError == "" {
+		t.Errorf("attempts = %d, last error %q, want a counted attempt", job.Attempts, job.LastError)
+	}
+	if !job.NextAttemptAt.After(start) {
+		t.Errorf("next attempt at %v, want after %v", job.NextAttemptAt, start)
+	}
+}
+
+func TestProcessFinalStatuses(t *testing.T) {
+	tests := []struct {
+		resp        fakeaccrual.Response
+		name        string
+		wantStatus  string
+		wantAccrual money.Amount
+	}{
+		{
+			name:        "processed",
+			resp:        fakeaccrual.Processed(money.FromCents(72998)),
+			wantStatus:  order.StatusProcessed,
+			wantAccrual: money.FromCents(72998),
+		},
+		{
+			name:       "invalid",
+			resp:       fakeaccrual.Invalid(),
+			wantStatus: order.StatusInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := fakeaccrual.New()
+			defer srv.Close()
+			srv.Script(testOrder, tt.resp)
+			pool, store := newTestPool(t, srv)
+
+			pool.process(context.Background(), newTestJob(testOrder))
+
+			if len(store.processed) != 1 || len(store.released) != 0 {
+				t.Fatalf("processed %d, released %d, want the order processed only",
+					len(store.processed), len(store.released))
+			}
+			got := store.processed[0]
+			if got.Status != tt.wantStatus || got.Accrual != tt.wantAccrual {
+				t.Errorf("order %s with %s, want %s with %s", got.Status, got.Accrual, tt.wantStatus, tt.wantAccrual)
+			}
+			if got.Provider != config.DefaultProvider {
+				t.Errorf("provider = %q, want %q", got.Provider, config.DefaultProvider)
+			}
+		})
+	}
+}